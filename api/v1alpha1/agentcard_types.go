@@ -39,6 +39,16 @@ type AgentCardStatus struct {
 
 	// GeneratedHTTPRoute is the name of the HTTPRoute created for this AgentCard.
 	GeneratedHTTPRoute string `json:"generatedHTTPRoute,omitempty"`
+
+	// CoveredPods is the number of pods labeled with this AgentCard, and therefore
+	// selected by the egress NetworkPolicy generated for it.
+	// +optional
+	CoveredPods int32 `json:"coveredPods,omitempty"`
+
+	// LastDiscoveryTime is when the agent's metadata was last fetched successfully.
+	// Only set on AgentCards created by the discovery controller.
+	// +optional
+	LastDiscoveryTime *metav1.Time `json:"lastDiscoveryTime,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -46,6 +56,7 @@ type AgentCardStatus struct {
 // +kubebuilder:resource:shortName=ac
 // +kubebuilder:printcolumn:name="Description",type=string,JSONPath=`.spec.description`
 // +kubebuilder:printcolumn:name="Protocols",type=string,JSONPath=`.spec.protocols`
+// +kubebuilder:printcolumn:name="Pods",type=integer,JSONPath=`.status.coveredPods`
 // +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
 // +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
@@ -44,8 +44,135 @@ type IngressPolicy struct {
 	// system:serviceaccount:{namespace}:{name} for JWT-based identity matching.
 	AllowedAgents []string `json:"allowedAgents,omitempty"`
 
-	// AllowedUsers is a list of user identifiers permitted to communicate with the selected agents.
+	// AllowedUsers is a list of user identifiers permitted to communicate with the selected
+	// agents, matched against the UserClaims of the caller's token. "*" allows any
+	// authenticated caller that is not a ServiceAccount, which must be listed in
+	// AllowedAgents, and a trailing "*" matches any value with that prefix.
+	// +kubebuilder:validation:items:Pattern=`^[^*]*\*?$`
 	AllowedUsers []string `json:"allowedUsers,omitempty"`
+
+	// UserClaims are the token claims AllowedUsers entries are matched against, such as
+	// sub, email, preferred_username or groups. An entry matches if any of the claims
+	// equals it or, for a list claim such as groups, contains it. Defaults to ["sub"].
+	// +optional
+	// +kubebuilder:validation:items:Pattern=`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`
+	UserClaims []string `json:"userClaims,omitempty"`
+
+	// ClaimPredicates are conditions on the caller's token claims that must all hold, in
+	// addition to the caller being one of the allowed agents or users.
+	// +optional
+	ClaimPredicates []ClaimPredicate `json:"claimPredicates,omitempty"`
+
+	// Issuers are the identity providers whose JWTs the gateway accepts. Defaults to the
+	// issuer configured on the controller with --default-jwt-issuer-url.
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Issuers []JWTIssuer `json:"issuers,omitempty"`
+
+	// KubernetesTokenReview authenticates callers that present Kubernetes ServiceAccount
+	// tokens, verified through the TokenReview API. It can be combined with Issuers, for
+	// example to accept in-cluster agents alongside human users.
+	// +optional
+	KubernetesTokenReview *KubernetesTokenReview `json:"kubernetesTokenReview,omitempty"`
+
+	// APIKey authenticates callers that cannot obtain tokens, such as CI bots, with keys
+	// stored in labelled Secrets in the policy's namespace.
+	// +optional
+	APIKey *APIKeyAuthentication `json:"apiKey,omitempty"`
+
+	// SidecarValidation makes the auth sidecar front the agent port and validate inbound
+	// JWTs itself, so that traffic reaching the pod without passing the gateway is still
+	// authenticated.
+	// +optional
+	SidecarValidation *SidecarValidation `json:"sidecarValidation,omitempty"`
+}
+
+// ClaimPredicate is a condition on a claim of the caller's token, such as groups including
+// ml-platform.
+// +kubebuilder:validation:XValidation:rule="self.operator != 'matches' || size(self.value) > 0",message="matches requires a non-empty pattern"
+type ClaimPredicate struct {
+	// Selector is the claim to test. Nested claims are addressed with dots
+	// (e.g. "realm_access.roles").
+	// +kubebuilder:validation:Pattern=`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`
+	Selector string `json:"selector"`
+
+	// Operator compares the claim with Value. eq and neq compare the whole claim, incl and
+	// excl test whether a list claim contains Value, and matches tests the claim against
+	// Value as a regular expression.
+	// +kubebuilder:validation:Enum=eq;neq;incl;excl;matches
+	Operator string `json:"operator"`
+
+	// Value is compared with the claim.
+	Value string `json:"value"`
+}
+
+// JWTIssuer is an identity provider whose JWTs authenticate callers at the gateway.
+type JWTIssuer struct {
+	// Name identifies the issuer in the generated AuthPolicy.
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	// +kubebuilder:validation:MaxLength=48
+	Name string `json:"name"`
+
+	// IssuerURL is the expected iss claim. Signing keys are discovered from
+	// {issuerUrl}/.well-known/openid-configuration unless JWKSURL is set.
+	// +kubebuilder:validation:Pattern=`^https?://`
+	IssuerURL string `json:"issuerUrl"`
+
+	// JWKSURL is where the issuer's signing keys are fetched, for issuers without OIDC
+	// discovery.
+	// +optional
+	// +kubebuilder:validation:Pattern=`^https?://`
+	JWKSURL string `json:"jwksUrl,omitempty"`
+
+	// Audiences are the accepted aud values. Tokens from this issuer must carry at least
+	// one of them. Not checked when empty.
+	// +optional
+	Audiences []string `json:"audiences,omitempty"`
+
+	// ClaimMapping sets identity claims from other claims of the token, so that
+	// authorization rules can match every issuer on the same claim names. Keys are the
+	// claims to set (e.g. "sub") and values the token claims they are read from
+	// (e.g. "oid"). Nested claims are addressed with dots.
+	// +optional
+	ClaimMapping map[string]string `json:"claimMapping,omitempty"`
+}
+
+// APIKeyAuthentication configures API key authentication at the gateway. Each selected
+// Secret holds one key under api_key, sent as "Authorization: APIKEY <key>". The Secret
+// name is the caller's sub, which AllowedUsers entries match.
+type APIKeyAuthentication struct {
+	// MatchLabels selects the Secrets holding accepted keys. Defaults to
+	// kagenti.com/api-key-policy={policy name}. Only Secrets in the policy's namespace
+	// that also carry authorino.kuadrant.io/managed-by=authorino are used.
+	// +optional
+	MatchLabels map[string]string `json:"matchLabels,omitempty"`
+}
+
+// KubernetesTokenReview configures ServiceAccount token authentication at the gateway.
+type KubernetesTokenReview struct {
+	// Audiences are the audiences a token must be bound to. Defaults to the AgentCard
+	// name, so callers request tokens for the agent they call.
+	// +optional
+	Audiences []string `json:"audiences,omitempty"`
+}
+
+// SidecarValidation configures inbound JWT validation in the auth sidecar. Tokens must be
+// signed by a key in the issuer's JWKS, carry one of the audiences, and have a sub that
+// is one of the resolved AllowedAgents or UserClaims matching AllowedUsers.
+type SidecarValidation struct {
+	// IssuerURL is the expected iss claim.
+	// +kubebuilder:validation:Pattern=`^https?://`
+	IssuerURL string `json:"issuerUrl"`
+
+	// JWKSURL is where the issuer's signing keys are fetched. Defaults to the jwks_uri
+	// advertised at {issuerUrl}/.well-known/openid-configuration.
+	// +optional
+	JWKSURL string `json:"jwksUrl,omitempty"`
+
+	// Audiences are the accepted aud values. Defaults to the AgentCard name.
+	// +optional
+	Audiences []string `json:"audiences,omitempty"`
 }
 
 // MCPToolsRef references an MCP tools VirtualServer.
@@ -63,21 +190,137 @@ type ExternalPolicy struct {
 	// +kubebuilder:validation:Enum=vault;exchange;passthrough;deny
 	// +kubebuilder:default=deny
 	DefaultMode string `json:"defaultMode"`
+
+	// IdentityProvider configures the OAuth token endpoint used by exchange rules.
+	// +optional
+	IdentityProvider *IdentityProviderSpec `json:"identityProvider,omitempty"`
+
+	// Vault configures the Vault server used by vault rules.
+	// +optional
+	Vault *VaultSpec `json:"vault,omitempty"`
+
+	// TLSInterception lets the sidecar terminate HTTPS tunnels to hosts whose rules it
+	// must see inside, so that credentials can be injected and method and path
+	// restrictions enforced on HTTPS requests. Hosts in passthrough mode stay tunnelled.
+	// +optional
+	TLSInterception *TLSInterceptionSpec `json:"tlsInterception,omitempty"`
+}
+
+// TLSInterceptionSpec configures the certificate authority the sidecar mints per-host
+// certificates from when it intercepts HTTPS tunnels.
+type TLSInterceptionSpec struct {
+	// CASecret is the name of a kubernetes.io/tls Secret holding the CA certificate and
+	// key. The key is mounted into the sidecar only; the certificate is added to the
+	// trust store of the pod's other containers.
+	CASecret string `json:"caSecret"`
+}
+
+// IdentityProviderSpec configures the OAuth 2.0 token endpoint the sidecar calls for
+// token exchange (RFC 8693).
+type IdentityProviderSpec struct {
+	// TokenEndpoint is the URL of the token endpoint.
+	TokenEndpoint string `json:"tokenEndpoint"`
+
+	// ClientID is the OAuth client ID the sidecar authenticates as.
+	// +optional
+	ClientID string `json:"clientId,omitempty"`
+
+	// ClientSecretRef references the Secret key holding the OAuth client secret.
+	// +optional
+	ClientSecretRef *SecretKeyRef `json:"clientSecretRef,omitempty"`
+}
+
+// VaultSpec configures the Vault server the sidecar reads credentials from. The sidecar
+// authenticates with the Kubernetes auth method using the pod's ServiceAccount token.
+type VaultSpec struct {
+	// Address is the Vault server URL, e.g. https://vault.vault.svc:8200.
+	Address string `json:"address"`
+
+	// Role is the Vault Kubernetes auth role the sidecar logs in as.
+	Role string `json:"role"`
+
+	// AuthMount is the mount path of the Kubernetes auth method.
+	// +optional
+	// +kubebuilder:default=kubernetes
+	AuthMount string `json:"authMount,omitempty"`
+
+	// CACertRef references the Secret key holding the PEM CA bundle that signs
+	// Vault's serving certificate.
+	// +optional
+	CACertRef *SecretKeyRef `json:"caCertRef,omitempty"`
+}
+
+// SecretKeyRef references a key in a Secret in the AgentPolicy's namespace.
+type SecretKeyRef struct {
+	// Name is the name of the Secret.
+	Name string `json:"name"`
+
+	// Key is the key within the Secret.
+	Key string `json:"key"`
 }
 
 // ExternalRule defines the credential handling policy for a specific external host.
+// When several rules match a request the most specific wins: an exact host over a
+// wildcard, a longer wildcard over a shorter one, then a rule with ports over one
+// without, then the longest matching path prefix.
 type ExternalRule struct {
-	// Host is the external hostname this rule applies to.
+	// Host is the external hostname this rule applies to. A leading "*." matches any
+	// subdomain, e.g. "*.googleapis.com" matches "storage.googleapis.com" but not
+	// "googleapis.com".
 	Host string `json:"host"`
 
+	// Ports restricts the rule to these destination ports. Empty matches any port.
+	// +optional
+	// +kubebuilder:validation:items:Minimum=1
+	// +kubebuilder:validation:items:Maximum=65535
+	Ports []int32 `json:"ports,omitempty"`
+
+	// Paths restricts the rule to requests whose path is one of these prefixes.
+	// Prefixes match whole path segments. Empty matches any path. Rules with paths
+	// only apply to requests the sidecar can inspect, not to HTTPS tunnels.
+	// +optional
+	// +kubebuilder:validation:items:Pattern=`^/`
+	Paths []string `json:"paths,omitempty"`
+
 	// Mode is the credential handling mode for this host.
-	// +kubebuilder:validation:Enum=vault;exchange;passthrough;deny
+	// +kubebuilder:validation:Enum=vault;exchange;secret;mtls;passthrough;deny
 	Mode string `json:"mode"`
 
+	// AllowedMethods restricts the HTTP methods permitted on this host. Empty allows
+	// every method.
+	// +optional
+	// +kubebuilder:validation:items:Enum=GET;HEAD;POST;PUT;PATCH;DELETE;OPTIONS
+	AllowedMethods []string `json:"allowedMethods,omitempty"`
+
+	// AllowedPaths restricts the request paths permitted on this host. Each entry is a
+	// path prefix such as "/repos", or a glob such as "/repos/*/issues" where "*"
+	// matches within one path segment. Empty allows every path. A rule with
+	// allowedMethods or allowedPaths cannot be enforced on an HTTPS tunnel, so the
+	// sidecar refuses CONNECT requests it matches.
+	// +optional
+	// +kubebuilder:validation:items:Pattern=`^/`
+	AllowedPaths []string `json:"allowedPaths,omitempty"`
+
 	// VaultPath is the path in a vault where credentials for this host are stored.
 	// +optional
 	VaultPath string `json:"vaultPath,omitempty"`
 
+	// SecretRef references the Secret key holding the credential for mode secret. The
+	// Secret is mounted into the sidecar; its value is never written to the sidecar
+	// ConfigMap.
+	// +optional
+	SecretRef *SecretKeyRef `json:"secretRef,omitempty"`
+
+	// ClientCertSecret is the name of a kubernetes.io/tls Secret holding the client
+	// certificate and key the sidecar presents to the host for mode mtls.
+	// +optional
+	ClientCertSecret string `json:"clientCertSecret,omitempty"`
+
+	// CACertRef references the Secret key holding the PEM CA bundle used to verify the
+	// host's certificate for mode mtls. Defaults to the system roots.
+	// +optional
+	CACertRef *SecretKeyRef `json:"caCertRef,omitempty"`
+
 	// Audience is the intended audience for token exchange.
 	// +optional
 	Audience string `json:"audience,omitempty"`
@@ -95,6 +338,30 @@ type ExternalRule struct {
 	// +optional
 	// +kubebuilder:default="Bearer "
 	HeaderPrefix string `json:"headerPrefix,omitempty"`
+
+	// RateLimit caps how often the agent may call this host. Requests over the limit
+	// are answered by the sidecar with 429.
+	// +optional
+	RateLimit *ExternalRateLimit `json:"rateLimit,omitempty"`
+}
+
+// ExternalRateLimit is a token-bucket limit on outbound requests matching a rule,
+// enforced separately by each pod's sidecar.
+type ExternalRateLimit struct {
+	// Requests is the number of requests allowed per window.
+	// +kubebuilder:validation:Minimum=1
+	Requests int32 `json:"requests"`
+
+	// Window is the period over which Requests are allowed.
+	// +optional
+	// +kubebuilder:default="1m"
+	Window metav1.Duration `json:"window,omitempty"`
+
+	// Burst is the number of requests that may be made at once before the rate
+	// applies. Defaults to Requests.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	Burst int32 `json:"burst,omitempty"`
 }
 
 // RateLimitSpec defines rate limiting configuration.
@@ -4,6 +4,8 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -11,6 +13,8 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
 
 	agentv1alpha1 "github.com/agentoperations/agent-access-control/api/v1alpha1"
@@ -34,6 +38,16 @@ func main() {
 	var enableLeaderElection bool
 	var gatewayName string
 	var gatewayNamespace string
+	var discoveryInterval time.Duration
+	var propagateLabels string
+	var enableWebhooks bool
+	var webhookPort int
+	var webhookCertDir string
+	var sidecarImage string
+	var defaultIssuerURL string
+	var defaultJWKSURL string
+	var defaultAudiences string
+	var defaultClaimMapping string
 
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
@@ -42,6 +56,25 @@ func main() {
 			"Enabling this will ensure there is only one active controller manager.")
 	flag.StringVar(&gatewayName, "gateway-name", "", "Name of the Gateway resource to configure (required).")
 	flag.StringVar(&gatewayNamespace, "gateway-namespace", "default", "Namespace of the Gateway resource.")
+	flag.DurationVar(&discoveryInterval, "discovery-interval", 5*time.Minute,
+		"How often discovered agents are re-fetched to pick up new skills and protocols.")
+	flag.StringVar(&propagateLabels, "discovery-propagate-labels", "tier,domain,team",
+		"Comma-separated workload label keys copied onto discovered AgentCards for policy selection.")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", false,
+		"Serve the pod and AgentPolicy admission webhooks. Requires a serving certificate in --webhook-cert-dir.")
+	flag.IntVar(&webhookPort, "webhook-port", 9443, "The port the admission webhook server binds to.")
+	flag.StringVar(&webhookCertDir, "webhook-cert-dir", "/tmp/k8s-webhook-server/serving-certs",
+		"Directory containing tls.crt and tls.key for the admission webhook server.")
+	flag.StringVar(&sidecarImage, "sidecar-image", "quay.io/azaalouk/agent-access-control-sidecar:latest",
+		"Image of the auth sidecar injected into pods labelled kagenti.com/inject-sidecar=true.")
+	flag.StringVar(&defaultIssuerURL, "default-jwt-issuer-url", "",
+		"JWT issuer accepted at the gateway for AgentPolicies that set no spec.ingress.issuers.")
+	flag.StringVar(&defaultJWKSURL, "default-jwt-jwks-url", "",
+		"JWKS URL of the default issuer. Discovered from the issuer when empty.")
+	flag.StringVar(&defaultAudiences, "default-jwt-audiences", "",
+		"Comma-separated audiences accepted from the default issuer. Not checked when empty.")
+	flag.StringVar(&defaultClaimMapping, "default-jwt-claim-mapping", "",
+		"Comma-separated claim=tokenClaim pairs mapping claims of the default issuer's tokens, e.g. sub=oid.")
 
 	opts := zap.Options{
 		Development: true,
@@ -56,11 +89,31 @@ func main() {
 		os.Exit(1)
 	}
 
+	var defaultIssuer *agentv1alpha1.JWTIssuer
+	if defaultIssuerURL != "" {
+		claimMapping, err := parseClaimMapping(defaultClaimMapping)
+		if err != nil {
+			setupLog.Error(err, "invalid flag", "flag", "default-jwt-claim-mapping")
+			os.Exit(1)
+		}
+		defaultIssuer = &agentv1alpha1.JWTIssuer{
+			Name:         "default",
+			IssuerURL:    defaultIssuerURL,
+			JWKSURL:      defaultJWKSURL,
+			Audiences:    splitList(defaultAudiences),
+			ClaimMapping: claimMapping,
+		}
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "agent-access-control.kagenti.github.com",
+		WebhookServer: webhook.NewServer(webhook.Options{
+			Port:    webhookPort,
+			CertDir: webhookCertDir,
+		}),
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -78,13 +131,48 @@ func main() {
 	}
 
 	if err = (&controller.AgentPolicyReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:        mgr.GetClient(),
+		Scheme:        mgr.GetScheme(),
+		DefaultIssuer: defaultIssuer,
+		APIReader:     mgr.GetAPIReader(),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "AgentPolicy")
 		os.Exit(1)
 	}
 
+	if err = (&controller.AgentDiscoveryReconciler{
+		Client:          mgr.GetClient(),
+		Scheme:          mgr.GetScheme(),
+		Interval:        discoveryInterval,
+		PropagateLabels: splitList(propagateLabels),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "AgentDiscovery")
+		os.Exit(1)
+	}
+
+	if enableWebhooks {
+		mgr.GetWebhookServer().Register(controller.PodLabelerPath, &webhook.Admission{
+			Handler: &controller.PodLabeler{
+				Client:  mgr.GetAPIReader(),
+				Decoder: admission.NewDecoder(mgr.GetScheme()),
+			},
+		})
+		mgr.GetWebhookServer().Register(controller.SidecarInjectorPath, &webhook.Admission{
+			Handler: &controller.SidecarInjector{
+				Client:        mgr.GetAPIReader(),
+				Decoder:       admission.NewDecoder(mgr.GetScheme()),
+				Image:         sidecarImage,
+				APIServerHost: os.Getenv("KUBERNETES_SERVICE_HOST"),
+			},
+		})
+		mgr.GetWebhookServer().Register(controller.AgentPolicyValidatorPath, &webhook.Admission{
+			Handler: &controller.AgentPolicyValidator{
+				Decoder:       admission.NewDecoder(mgr.GetScheme()),
+				DefaultIssuer: defaultIssuer,
+			},
+		})
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
@@ -100,3 +188,31 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseClaimMapping parses comma-separated claim=tokenClaim pairs.
+func parseClaimMapping(value string) (map[string]string, error) {
+	items := splitList(value)
+	if len(items) == 0 {
+		return nil, nil
+	}
+	mapping := make(map[string]string, len(items))
+	for _, item := range items {
+		claim, from, ok := strings.Cut(item, "=")
+		if !ok || claim == "" || from == "" {
+			return nil, fmt.Errorf("expected claim=tokenClaim, got %q", item)
+		}
+		mapping[claim] = from
+	}
+	return mapping, nil
+}
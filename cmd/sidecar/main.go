@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/agentoperations/agent-access-control/internal/sidecar"
+	"github.com/agentoperations/agent-access-control/pkg/sidecarconfig"
+)
+
+func main() {
+	var configPath string
+	var listenAddr string
+	var inboundAddr string
+	var statusAddr string
+	var tokenPath string
+	var vaultCacheTTL time.Duration
+	var auditLog string
+	var auditOTLPFile string
+	var trustBundlePath string
+
+	flag.StringVar(&configPath, "config", "/etc/agent-sidecar/config.yaml", "Path to the sidecar config.yaml.")
+	flag.StringVar(&listenAddr, "listen", "127.0.0.1:15001", "The address the forward proxy binds to.")
+	flag.StringVar(&inboundAddr, "inbound-listen", "",
+		"If set, the address the inbound reverse proxy, which validates JWTs before forwarding to the agent, binds to.")
+	flag.StringVar(&statusAddr, "status-listen", "127.0.0.1:15020",
+		"The address the status endpoint (/status, /metrics, /healthz) binds to.")
+	flag.StringVar(&tokenPath, "token-path", sidecar.DefaultServiceAccountTokenPath,
+		"The ServiceAccount token presented to Vault and exchanged when a request carries no bearer token.")
+	flag.DurationVar(&vaultCacheTTL, "vault-cache-ttl", sidecar.DefaultVaultCacheTTL,
+		"How long secrets read from Vault are cached.")
+	flag.StringVar(&auditLog, "audit-log", "stdout",
+		"Where JSON audit records of outbound requests are written: stdout or none.")
+	flag.StringVar(&auditOTLPFile, "audit-otlp-file", "",
+		"If set, audit records are also appended to this file as OTLP/JSON log records.")
+
+	flag.StringVar(&trustBundlePath, "write-trust-bundle", "",
+		"If set, write the system CA bundle plus the tlsInterception CA certificate to this path and exit.")
+
+	opts := zap.Options{}
+	opts.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	logger := ctrl.Log.WithName("sidecar")
+
+	if trustBundlePath != "" {
+		cfg, err := sidecarconfig.Load(configPath)
+		if err == nil {
+			err = sidecar.WriteTrustBundle(cfg, trustBundlePath)
+		}
+		if err != nil {
+			logger.Error(err, "unable to write trust bundle", "path", trustBundlePath)
+			os.Exit(1)
+		}
+		logger.Info("wrote trust bundle", "path", trustBundlePath)
+		return
+	}
+
+	var auditJSON io.Writer
+	switch auditLog {
+	case "stdout":
+		auditJSON = os.Stdout
+	case "none":
+	default:
+		logger.Error(nil, "invalid --audit-log, want stdout or none", "value", auditLog)
+		os.Exit(1)
+	}
+	var auditOTLP io.Writer
+	if auditOTLPFile != "" {
+		f, err := os.OpenFile(auditOTLPFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			logger.Error(err, "unable to open audit file", "path", auditOTLPFile)
+			os.Exit(1)
+		}
+		defer f.Close()
+		auditOTLP = f
+	}
+
+	registry := prometheus.NewRegistry()
+	proxy := &sidecar.Proxy{
+		Log:     logger.WithName("proxy"),
+		Metrics: sidecar.NewMetrics(registry),
+		Audit:   sidecar.NewAuditLog(auditJSON, auditOTLP),
+	}
+	var inbound *sidecar.InboundProxy
+	if inboundAddr != "" {
+		inbound = &sidecar.InboundProxy{Log: logger.WithName("inbound")}
+	}
+	watcher := &sidecar.ConfigWatcher{
+		Path:    configPath,
+		Proxy:   proxy,
+		Inbound: inbound,
+		Options: sidecar.RuntimeOptions{
+			TokenPath:     tokenPath,
+			VaultCacheTTL: vaultCacheTTL,
+		},
+		Log: logger.WithName("config"),
+	}
+	if err := watcher.Reload(); err != nil {
+		logger.Error(err, "unable to load config", "path", configPath)
+		os.Exit(1)
+	}
+
+	server := &http.Server{
+		Addr:              listenAddr,
+		Handler:           proxy,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	statusMux := http.NewServeMux()
+	statusMux.Handle("/status", watcher)
+	statusMux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	statusMux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	statusServer := &http.Server{
+		Addr:              statusAddr,
+		Handler:           statusMux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		if err := watcher.Start(ctx); err != nil {
+			logger.Error(err, "config hot reload disabled")
+		}
+	}()
+
+	go func() {
+		if err := statusServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error(err, "problem running status endpoint")
+		}
+	}()
+
+	var inboundServer *http.Server
+	if inbound != nil {
+		inboundServer = &http.Server{
+			Addr:              inboundAddr,
+			Handler:           inbound,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			logger.Info("starting inbound proxy", "address", inboundAddr)
+			if err := inboundServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error(err, "problem running inbound proxy")
+				os.Exit(1)
+			}
+		}()
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = statusServer.Shutdown(shutdownCtx)
+		if inboundServer != nil {
+			_ = inboundServer.Shutdown(shutdownCtx)
+		}
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info("starting forward proxy", "address", listenAddr, "status", statusAddr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error(err, "problem running forward proxy")
+		os.Exit(1)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -13,7 +14,9 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
 
@@ -37,6 +40,7 @@ type AgentCardReconciler struct {
 // +kubebuilder:rbac:groups=kagenti.com,resources=agentcards/finalizers,verbs=update
 // +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=mcp.kagenti.com,resources=mcpserverregistrations,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
 
 // Reconcile handles reconciliation of AgentCard resources.
 func (r *AgentCardReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -113,8 +117,19 @@ func (r *AgentCardReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		}
 	}
 
+	// Count the pods labeled for this card, i.e. covered by its egress NetworkPolicy.
+	var podList metav1.PartialObjectMetadataList
+	podList.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("PodList"))
+	if err := r.List(ctx, &podList,
+		client.InNamespace(card.Namespace),
+		client.MatchingLabels{labelAgentCard: card.Name},
+	); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to list pods for AgentCard: %w", err)
+	}
+
 	// Update status.
 	card.Status.GeneratedHTTPRoute = desired.Name
+	card.Status.CoveredPods = int32(len(podList.Items))
 	r.setReadyCondition(ctx, &card, metav1.ConditionTrue, "Reconciled", "AgentCard reconciled successfully")
 
 	return ctrl.Result{}, nil
@@ -182,11 +197,29 @@ func isCRDNotFound(err error) bool {
 	return apierrors.IsNotFound(err)
 }
 
+// findAgentCardForPod maps a labeled pod to the AgentCard it backs.
+func (r *AgentCardReconciler) findAgentCardForPod(ctx context.Context, obj client.Object) []reconcile.Request {
+	cardName := obj.GetLabels()[labelAgentCard]
+	if cardName == "" {
+		return nil
+	}
+	return []reconcile.Request{{
+		NamespacedName: types.NamespacedName{
+			Name:      cardName,
+			Namespace: obj.GetNamespace(),
+		},
+	}}
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *AgentCardReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&v1alpha1.AgentCard{}).
 		Owns(&gatewayv1.HTTPRoute{}).
+		WatchesMetadata(
+			&corev1.Pod{},
+			handler.EnqueueRequestsFromMapFunc(r.findAgentCardForPod),
+		).
 		Complete(r)
 }
 
@@ -3,9 +3,12 @@ package controller
 import (
 	"context"
 	"fmt"
+	"slices"
+	"strings"
 
 	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -26,12 +29,24 @@ import (
 
 const (
 	agentPolicyFinalizer = "kagenti.com/agentpolicy-finalizer"
+
+	// conditionSecretsResolved reports whether the Secrets referenced by the external
+	// policy exist and contain the referenced keys.
+	conditionSecretsResolved = "SecretsResolved"
 )
 
 // AgentPolicyReconciler reconciles AgentPolicy objects.
 type AgentPolicyReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// DefaultIssuer authenticates callers for ingress policies that configure no
+	// authentication. When nil, such a policy gets a deny-all AuthPolicy.
+	DefaultIssuer *v1alpha1.JWTIssuer
+
+	// APIReader reads referenced Secrets straight from the API server, so that Secret
+	// contents are never cached by the manager. The client is used when nil.
+	APIReader client.Reader
 }
 
 // +kubebuilder:rbac:groups=kagenti.com,resources=agentpolicies,verbs=get;list;watch;create;update;patch;delete
@@ -42,6 +57,7 @@ type AgentPolicyReconciler struct {
 // +kubebuilder:rbac:groups=kuadrant.io,resources=authpolicies,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=kuadrant.io,resources=ratelimitpolicies,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
 // +kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile handles reconciliation of AgentPolicy resources.
@@ -112,7 +128,13 @@ func (r *AgentPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 
 		// Create AuthPolicy if ingress policy is defined.
 		if policy.Spec.Ingress != nil {
-			authPolicy := BuildAuthPolicy(&policy, card, httpRouteName)
+			authPolicy, err := BuildAuthPolicy(&policy, card, httpRouteName, r.DefaultIssuer)
+			if err != nil {
+				// Fail closed: replace whatever AuthPolicy the route has with one that
+				// denies everything until the policy is fixed.
+				reconcileErrors = append(reconcileErrors, fmt.Errorf("failed to build AuthPolicy for card %s, denying all traffic: %w", card.Name, err))
+				authPolicy = BuildDenyAllAuthPolicy(&policy, card, httpRouteName)
+			}
 			if err := r.createOrUpdateUnstructured(ctx, authPolicy); err != nil {
 				if isCRDNotFoundPolicy(err) {
 					logger.Info("AuthPolicy CRD not installed, skipping", "error", err.Error())
@@ -147,8 +169,8 @@ func (r *AgentPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		}
 	}
 
-	// Create sidecar ConfigMaps if external policy is defined.
-	if policy.Spec.External != nil {
+	// Create sidecar ConfigMaps if external policy or sidecar inbound validation is defined.
+	if policy.Spec.External != nil || (policy.Spec.Ingress != nil && policy.Spec.Ingress.SidecarValidation != nil) {
 		for i := range cardList.Items {
 			card := &cardList.Items[i]
 
@@ -170,6 +192,13 @@ func (r *AgentPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		}
 	}
 
+	// Verify the Secrets the sidecar config references. Missing Secrets do not block the
+	// ConfigMap; they are reported through the SecretsResolved condition.
+	missingSecrets, err := r.missingSecretRefs(ctx, &policy)
+	if err != nil {
+		reconcileErrors = append(reconcileErrors, err)
+	}
+
 	// Create NetworkPolicies if external policy has deny as default mode.
 	if policy.Spec.External != nil && policy.Spec.External.DefaultMode == "deny" {
 		for i := range cardList.Items {
@@ -196,6 +225,7 @@ func (r *AgentPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	// Update status.
 	policy.Status.MatchedAgentCards = len(cardList.Items)
 	policy.Status.GeneratedResources = generatedResources
+	setSecretsResolvedCondition(&policy, missingSecrets)
 
 	if len(reconcileErrors) > 0 {
 		errMsg := fmt.Sprintf("encountered %d error(s) during reconciliation", len(reconcileErrors))
@@ -211,6 +241,65 @@ func (r *AgentPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	return ctrl.Result{}, nil
 }
 
+// secretReader returns the reader Secrets are fetched with.
+func (r *AgentPolicyReconciler) secretReader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+	return r.Client
+}
+
+// missingSecretRefs returns a description of each Secret key referenced by the policy
+// that does not exist.
+func (r *AgentPolicyReconciler) missingSecretRefs(ctx context.Context, policy *v1alpha1.AgentPolicy) ([]string, error) {
+	var missing []string
+	report := func(msg string) {
+		// A TLS Secret is referenced once per key, so a missing Secret is reported once.
+		if !slices.Contains(missing, msg) {
+			missing = append(missing, msg)
+		}
+	}
+	for _, use := range policySecretRefs(policy) {
+		var secret corev1.Secret
+		err := r.secretReader().Get(ctx, types.NamespacedName{Name: use.Ref.Name, Namespace: policy.Namespace}, &secret)
+		switch {
+		case apierrors.IsNotFound(err):
+			report(fmt.Sprintf("Secret %s referenced by %s not found", use.Ref.Name, use.Field))
+		case err != nil:
+			return missing, fmt.Errorf("failed to get Secret %s: %w", use.Ref.Name, err)
+		case use.Type != "" && secret.Type != use.Type:
+			report(fmt.Sprintf("Secret %s referenced by %s is of type %s, not %s", use.Ref.Name, use.Field, secret.Type, use.Type))
+		default:
+			if _, ok := secret.Data[use.Ref.Key]; !ok {
+				report(fmt.Sprintf("Secret %s referenced by %s has no key %q", use.Ref.Name, use.Field, use.Ref.Key))
+			}
+		}
+	}
+	return missing, nil
+}
+
+// setSecretsResolvedCondition records whether the policy's Secret references resolve.
+// The condition is omitted for policies that reference no Secrets.
+func setSecretsResolvedCondition(policy *v1alpha1.AgentPolicy, missing []string) {
+	if len(policySecretRefs(policy)) == 0 {
+		meta.RemoveStatusCondition(&policy.Status.Conditions, conditionSecretsResolved)
+		return
+	}
+
+	cond := metav1.Condition{
+		Type:    conditionSecretsResolved,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Resolved",
+		Message: "All referenced Secrets exist",
+	}
+	if len(missing) > 0 {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = "SecretNotFound"
+		cond.Message = strings.Join(missing, "; ")
+	}
+	meta.SetStatusCondition(&policy.Status.Conditions, cond)
+}
+
 // setReadyCondition updates the Ready condition on the AgentPolicy status and persists it.
 func (r *AgentPolicyReconciler) setReadyCondition(ctx context.Context, policy *v1alpha1.AgentPolicy, status metav1.ConditionStatus, reason, message string) {
 	logger := log.FromContext(ctx)
@@ -247,8 +336,12 @@ func (r *AgentPolicyReconciler) createOrUpdateUnstructured(ctx context.Context,
 		return err
 	}
 
-	// Resource already exists; skip update to avoid reconcile loops.
-	return nil
+	// Skip update if the spec hasn't changed to avoid reconcile loops.
+	if equality.Semantic.DeepEqual(existing.Object["spec"], desired.Object["spec"]) {
+		return nil
+	}
+	existing.Object["spec"] = desired.Object["spec"]
+	return r.Update(ctx, existing)
 }
 
 // createOrUpdateConfigMap creates or updates a ConfigMap resource.
@@ -268,13 +361,22 @@ func (r *AgentPolicyReconciler) createOrUpdateConfigMap(ctx context.Context, des
 	}
 
 	// Skip update if data hasn't changed to avoid watch-triggered reconcile loops.
-	if mapsEqual(existing.Data, desired.Data) {
+	desiredSecrets := desired.Annotations[annotationSidecarSecrets]
+	if mapsEqual(existing.Data, desired.Data) && existing.Annotations[annotationSidecarSecrets] == desiredSecrets {
 		return nil
 	}
 
 	existing.Data = desired.Data
 	existing.Labels = desired.Labels
 	existing.OwnerReferences = desired.OwnerReferences
+	if desiredSecrets != "" {
+		if existing.Annotations == nil {
+			existing.Annotations = map[string]string{}
+		}
+		existing.Annotations[annotationSidecarSecrets] = desiredSecrets
+	} else {
+		delete(existing.Annotations, annotationSidecarSecrets)
+	}
 	return r.Update(ctx, existing)
 }
 
@@ -354,6 +456,32 @@ func (r *AgentPolicyReconciler) findPoliciesForAgentCard(ctx context.Context, ob
 	return requests
 }
 
+// findPoliciesForSecret maps a Secret to the AgentPolicies that reference it.
+func (r *AgentPolicyReconciler) findPoliciesForSecret(ctx context.Context, obj client.Object) []reconcile.Request {
+	logger := log.FromContext(ctx)
+
+	var policyList v1alpha1.AgentPolicyList
+	if err := r.List(ctx, &policyList, client.InNamespace(obj.GetNamespace())); err != nil {
+		logger.Error(err, "failed to list AgentPolicies for mapping")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for i := range policyList.Items {
+		policy := &policyList.Items[i]
+		for _, use := range policySecretRefs(policy) {
+			if use.Ref.Name == obj.GetName() {
+				requests = append(requests, reconcile.Request{
+					NamespacedName: types.NamespacedName{Name: policy.Name, Namespace: policy.Namespace},
+				})
+				break
+			}
+		}
+	}
+
+	return requests
+}
+
 // labelsMatchSelector checks if all selector labels are present in the object's labels.
 func labelsMatchSelector(objectLabels, selectorLabels map[string]string) bool {
 	for key, val := range selectorLabels {
@@ -373,5 +501,10 @@ func (r *AgentPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			&v1alpha1.AgentCard{},
 			handler.EnqueueRequestsFromMapFunc(r.findPoliciesForAgentCard),
 		).
+		// Only Secret metadata is cached; missingSecretRefs reads the keys uncached.
+		WatchesMetadata(
+			&corev1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(r.findPoliciesForSecret),
+		).
 		Complete(r)
 }
@@ -0,0 +1,245 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+
+	v1alpha1 "github.com/agentoperations/agent-access-control/api/v1alpha1"
+)
+
+func TestMissingSecretRefs(t *testing.T) {
+	policy := testAgentPolicy("premium-policy", "default")
+	policy.Spec.External.IdentityProvider = &v1alpha1.IdentityProviderSpec{
+		TokenEndpoint:   "https://idp.example.com/token",
+		ClientSecretRef: &v1alpha1.SecretKeyRef{Name: "idp-client", Key: "client-secret"},
+	}
+	policy.Spec.External.Vault = &v1alpha1.VaultSpec{
+		Address:   "https://vault.example.com",
+		Role:      "weather-agent",
+		CACertRef: &v1alpha1.SecretKeyRef{Name: "vault-ca", Key: "ca.crt"},
+	}
+
+	policy.Spec.External.Rules = append(policy.Spec.External.Rules, v1alpha1.ExternalRule{
+		Host:      "api.openai.com",
+		Mode:      "secret",
+		SecretRef: &v1alpha1.SecretKeyRef{Name: "openai", Key: "api-key"},
+	})
+
+	openaiSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "openai", Namespace: "default"},
+		Data:       map[string][]byte{"api-key": []byte("sk-test")},
+	}
+	idpSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "idp-client", Namespace: "default"},
+		Data:       map[string][]byte{"wrong-key": []byte("x")},
+	}
+	r := &AgentPolicyReconciler{
+		Client: fake.NewClientBuilder().WithScheme(testWebhookScheme()).WithObjects(idpSecret, openaiSecret).Build(),
+	}
+
+	missing, err := r.missingSecretRefs(context.Background(), policy)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(missing) != 2 {
+		t.Fatalf("expected 2 missing references, got %v", missing)
+	}
+	if !strings.Contains(missing[0], `has no key "client-secret"`) || !strings.Contains(missing[1], "vault-ca") {
+		t.Errorf("unexpected missing references %v", missing)
+	}
+
+	setSecretsResolvedCondition(policy, missing)
+	cond := meta.FindStatusCondition(policy.Status.Conditions, conditionSecretsResolved)
+	if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != "SecretNotFound" {
+		t.Fatalf("expected SecretsResolved=False/SecretNotFound, got %+v", cond)
+	}
+
+	setSecretsResolvedCondition(policy, nil)
+	cond = meta.FindStatusCondition(policy.Status.Conditions, conditionSecretsResolved)
+	if cond == nil || cond.Status != metav1.ConditionTrue {
+		t.Errorf("expected SecretsResolved=True, got %+v", cond)
+	}
+
+	policy.Spec.External.IdentityProvider = nil
+	policy.Spec.External.Vault = nil
+	policy.Spec.External.Rules = policy.Spec.External.Rules[:1]
+	setSecretsResolvedCondition(policy, nil)
+	if meta.FindStatusCondition(policy.Status.Conditions, conditionSecretsResolved) != nil {
+		t.Error("expected SecretsResolved to be removed when no Secrets are referenced")
+	}
+}
+
+func TestMissingSecretRefs_RuleSecret(t *testing.T) {
+	policy := testAgentPolicy("premium-policy", "default")
+	policy.Spec.External.Rules = append(policy.Spec.External.Rules, v1alpha1.ExternalRule{
+		Host:      "api.openai.com",
+		Mode:      "secret",
+		SecretRef: &v1alpha1.SecretKeyRef{Name: "openai", Key: "api-key"},
+	})
+	r := &AgentPolicyReconciler{
+		Client: fake.NewClientBuilder().WithScheme(testWebhookScheme()).Build(),
+	}
+
+	missing, err := r.missingSecretRefs(context.Background(), policy)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(missing) != 1 || !strings.Contains(missing[0], "Secret openai referenced by spec.external.rules[1].secretRef not found") {
+		t.Errorf("unexpected missing references %v", missing)
+	}
+}
+
+func TestMissingSecretRefs_APIReader(t *testing.T) {
+	policy := testAgentPolicy("premium-policy", "default")
+	policy.Spec.External.Rules = append(policy.Spec.External.Rules, v1alpha1.ExternalRule{
+		Host:      "api.openai.com",
+		Mode:      "secret",
+		SecretRef: &v1alpha1.SecretKeyRef{Name: "openai", Key: "api-key"},
+	})
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "openai", Namespace: "default"},
+		Data:       map[string][]byte{"api-key": []byte("sk-test")},
+	}
+	// The cached client holds no Secrets; keys are read through the API reader.
+	r := &AgentPolicyReconciler{
+		Client:    fake.NewClientBuilder().WithScheme(testWebhookScheme()).Build(),
+		APIReader: fake.NewClientBuilder().WithScheme(testWebhookScheme()).WithObjects(secret).Build(),
+	}
+
+	missing, err := r.missingSecretRefs(context.Background(), policy)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(missing) != 0 {
+		t.Errorf("expected the Secret to be read through the API reader, got %v", missing)
+	}
+}
+
+func TestMissingSecretRefs_ClientCertSecret(t *testing.T) {
+	policy := testAgentPolicy("premium-policy", "default")
+	policy.Spec.External.Rules = append(policy.Spec.External.Rules, v1alpha1.ExternalRule{
+		Host:             "partner.example.com",
+		Mode:             "mtls",
+		ClientCertSecret: "partner-client",
+	})
+
+	tests := []struct {
+		name    string
+		objects []client.Object
+		want    string
+	}{
+		{
+			name: "missing",
+			want: "Secret partner-client referenced by spec.external.rules[1].clientCertSecret not found",
+		},
+		{
+			name: "wrong type",
+			objects: []client.Object{&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "partner-client", Namespace: "default"},
+				Type:       corev1.SecretTypeOpaque,
+				Data:       map[string][]byte{"tls.crt": []byte("x"), "tls.key": []byte("x")},
+			}},
+			want: "is of type Opaque, not kubernetes.io/tls",
+		},
+		{
+			name: "resolved",
+			objects: []client.Object{&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "partner-client", Namespace: "default"},
+				Type:       corev1.SecretTypeTLS,
+				Data:       map[string][]byte{"tls.crt": []byte("x"), "tls.key": []byte("x")},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &AgentPolicyReconciler{
+				Client: fake.NewClientBuilder().WithScheme(testWebhookScheme()).WithObjects(tt.objects...).Build(),
+			}
+			missing, err := r.missingSecretRefs(context.Background(), policy)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.want == "" {
+				if len(missing) != 0 {
+					t.Errorf("expected no missing references, got %v", missing)
+				}
+				return
+			}
+			if len(missing) != 1 || !strings.Contains(missing[0], tt.want) {
+				t.Errorf("expected one missing reference containing %q, got %v", tt.want, missing)
+			}
+		})
+	}
+}
+
+func TestReconcile_DeniesAllWhenAuthPolicyCannotBeBuilt(t *testing.T) {
+	scheme := testWebhookScheme()
+	_ = gatewayv1.Install(scheme)
+
+	card := testAgentCard("weather-agent", "default")
+	card.Labels = map[string]string{"tier": "premium"}
+	route := &gatewayv1.HTTPRoute{ObjectMeta: metav1.ObjectMeta{
+		Name:      "weather-agent",
+		Namespace: "default",
+		Labels:    map[string]string{labelAgentCard: card.Name},
+	}}
+	policy := testAgentPolicy("premium-policy", "default")
+	policy.Spec.External = nil
+	policy.Spec.Ingress.Issuers = nil
+
+	// An AuthPolicy rendered from an earlier, valid version of the policy.
+	valid := testAgentPolicy("premium-policy", "default")
+	stale, err := BuildAuthPolicy(valid, card, route.Name, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(policy, card, route, stale).
+		WithStatusSubresource(policy).
+		Build()
+	r := &AgentPolicyReconciler{Client: c, Scheme: scheme}
+
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: policy.Name, Namespace: policy.Namespace}}
+	if _, err := r.Reconcile(context.Background(), req); err == nil {
+		t.Fatal("expected a reconcile error for a policy without authentication")
+	}
+
+	authPolicy := &unstructured.Unstructured{}
+	authPolicy.SetGroupVersionKind(stale.GroupVersionKind())
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "ap-weather-agent", Namespace: "default"}, authPolicy); err != nil {
+		t.Fatalf("failed to get AuthPolicy: %v", err)
+	}
+	authorization, _, _ := unstructured.NestedMap(authPolicy.Object, "spec", "rules", "authorization")
+	if _, ok := authorization["deny-all"]; !ok || len(authorization) != 1 {
+		t.Errorf("expected the stale AuthPolicy to be replaced with deny-all, got %v", authorization)
+	}
+
+	rlp := &unstructured.Unstructured{}
+	rlp.SetGroupVersionKind(schema.GroupVersionKind{Group: "kuadrant.io", Version: "v1", Kind: "RateLimitPolicy"})
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "rlp-weather-agent", Namespace: "default"}, rlp); err != nil {
+		t.Errorf("expected the RateLimitPolicy to still be reconciled: %v", err)
+	}
+
+	var updated v1alpha1.AgentPolicy
+	if err := c.Get(context.Background(), req.NamespacedName, &updated); err != nil {
+		t.Fatalf("failed to get AgentPolicy: %v", err)
+	}
+	cond := meta.FindStatusCondition(updated.Status.Conditions, "Ready")
+	if cond == nil || cond.Status != metav1.ConditionFalse || !strings.Contains(cond.Message, "denying all traffic") {
+		t.Errorf("expected Ready=False reporting the denial, got %+v", cond)
+	}
+}
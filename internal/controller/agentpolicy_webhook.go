@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	v1alpha1 "github.com/agentoperations/agent-access-control/api/v1alpha1"
+)
+
+const (
+	// AgentPolicyValidatorPath is the path the AgentPolicy validating webhook is served on.
+	AgentPolicyValidatorPath = "/validate-kagenti-com-v1alpha1-agentpolicy"
+)
+
+// AgentPolicyValidator is a validating admission webhook that rejects AgentPolicies
+// whose ingress rules cannot be rendered into an AuthPolicy, or whose external rules
+// cannot be rendered into a valid sidecar config, so that they are caught when applied
+// rather than by the reconciler closing the route or failing on every reconcile.
+type AgentPolicyValidator struct {
+	Decoder admission.Decoder
+
+	// DefaultIssuer is the controller's --default-jwt-issuer-url issuer, if any.
+	DefaultIssuer *v1alpha1.JWTIssuer
+}
+
+// +kubebuilder:webhook:path=/validate-kagenti-com-v1alpha1-agentpolicy,mutating=false,failurePolicy=fail,sideEffects=None,groups=kagenti.com,resources=agentpolicies,verbs=create;update,versions=v1alpha1,name=agentpolicies.kagenti.com,admissionReviewVersions=v1
+
+// Handle validates the policy's ingress and external rules. Updates that leave the spec unchanged,
+// such as finalizer and status changes, are admitted so that policies created before
+// the webhook can still be reconciled and deleted.
+func (w *AgentPolicyValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	policy := &v1alpha1.AgentPolicy{}
+	if err := w.Decoder.Decode(req, policy); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	if len(req.OldObject.Raw) > 0 {
+		old := &v1alpha1.AgentPolicy{}
+		if err := w.Decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		if equality.Semantic.DeepEqual(old.Spec, policy.Spec) {
+			return admission.Allowed("spec unchanged")
+		}
+	}
+
+	if err := ValidateIngress(policy, w.DefaultIssuer); err != nil {
+		return admission.Denied(err.Error())
+	}
+	if err := ValidateSidecarConfig(policy); err != nil {
+		return admission.Denied(err.Error())
+	}
+	return admission.Allowed("")
+}
@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	v1alpha1 "github.com/agentoperations/agent-access-control/api/v1alpha1"
+)
+
+func policyAdmissionRequest(t *testing.T, operation admissionv1.Operation, policy, old *v1alpha1.AgentPolicy) admission.Request {
+	t.Helper()
+	raw := func(p *v1alpha1.AgentPolicy) runtime.RawExtension {
+		if p == nil {
+			return runtime.RawExtension{}
+		}
+		data, err := json.Marshal(p)
+		if err != nil {
+			t.Fatalf("failed to marshal policy: %v", err)
+		}
+		return runtime.RawExtension{Raw: data}
+	}
+	return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: operation,
+		Namespace: policy.Namespace,
+		Object:    raw(policy),
+		OldObject: raw(old),
+	}}
+}
+
+func TestAgentPolicyValidator(t *testing.T) {
+	validator := &AgentPolicyValidator{Decoder: admission.NewDecoder(testWebhookScheme())}
+
+	valid := testAgentPolicy("premium-policy", "default")
+	noAuth := testAgentPolicy("premium-policy", "default")
+	noAuth.Spec.Ingress.Issuers = nil
+
+	resp := validator.Handle(context.Background(), policyAdmissionRequest(t, admissionv1.Create, valid, nil))
+	if !resp.Allowed {
+		t.Errorf("expected a valid policy to be admitted, got %+v", resp.Result)
+	}
+
+	resp = validator.Handle(context.Background(), policyAdmissionRequest(t, admissionv1.Create, noAuth, nil))
+	if resp.Allowed {
+		t.Error("expected a policy without authentication to be rejected")
+	}
+
+	resp = validator.Handle(context.Background(), policyAdmissionRequest(t, admissionv1.Update, noAuth, valid))
+	if resp.Allowed {
+		t.Error("expected an update removing authentication to be rejected")
+	}
+
+	// Finalizer changes on a policy admitted before the webhook existed still go through.
+	withFinalizer := noAuth.DeepCopy()
+	withFinalizer.Finalizers = []string{agentPolicyFinalizer}
+	resp = validator.Handle(context.Background(), policyAdmissionRequest(t, admissionv1.Update, withFinalizer, noAuth))
+	if !resp.Allowed {
+		t.Errorf("expected an update leaving the spec unchanged to be admitted, got %+v", resp.Result)
+	}
+
+	badPattern := testAgentPolicy("premium-policy", "default")
+	badPattern.Spec.Ingress.ClaimPredicates = []v1alpha1.ClaimPredicate{{Selector: "scope", Operator: "matches", Value: "agents:("}}
+	resp = validator.Handle(context.Background(), policyAdmissionRequest(t, admissionv1.Create, badPattern, nil))
+	if resp.Allowed || !strings.Contains(resp.Result.Message, "claimPredicates[0]") {
+		t.Errorf("expected an invalid matches pattern to be rejected, got %+v", resp.Result)
+	}
+
+	overlapping := testAgentPolicy("premium-policy", "default")
+	overlapping.Spec.External = &v1alpha1.ExternalPolicy{
+		DefaultMode: "deny",
+		Rules: []v1alpha1.ExternalRule{
+			{Host: "api.github.com", Mode: "passthrough"},
+			{Host: "api.github.com", Mode: "deny"},
+			{Host: "api.openai.com", Mode: "secret"},
+		},
+	}
+	resp = validator.Handle(context.Background(), policyAdmissionRequest(t, admissionv1.Create, overlapping, nil))
+	if resp.Allowed || !strings.Contains(resp.Result.Message, "overlaps external.rules[0]") ||
+		!strings.Contains(resp.Result.Message, "external.rules[2].secretFile") {
+		t.Errorf("expected overlapping rules and a secret rule without secretRef to be rejected, got %+v", resp.Result)
+	}
+
+	validator.DefaultIssuer = &v1alpha1.JWTIssuer{Name: "default", IssuerURL: "https://issuer.example.com"}
+	resp = validator.Handle(context.Background(), policyAdmissionRequest(t, admissionv1.Create, noAuth, nil))
+	if !resp.Allowed {
+		t.Errorf("expected the default issuer to authenticate the policy, got %+v", resp.Result)
+	}
+}
@@ -1,9 +1,17 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
+	"maps"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -15,12 +23,21 @@ import (
 	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
 
 	v1alpha1 "github.com/agentoperations/agent-access-control/api/v1alpha1"
+	"github.com/agentoperations/agent-access-control/pkg/sidecarconfig"
 )
 
 const (
 	labelManagedBy = "kagenti.com/managed-by"
 	labelAgentCard = "kagenti.com/agent-card"
 	managedByValue = "agent-access-control"
+
+	// labelAPIKeyPolicy selects the API key Secrets accepted by the AgentPolicy it names.
+	labelAPIKeyPolicy = "kagenti.com/api-key-policy"
+
+	// labelAuthorinoManagedBy marks the Secrets Authorino watches; API key Secrets
+	// without it are never loaded.
+	labelAuthorinoManagedBy = "authorino.kuadrant.io/managed-by"
+	authorinoManagedByValue = "authorino"
 )
 
 // commonLabels returns the standard labels applied to all generated resources.
@@ -120,6 +137,46 @@ func BuildHTTPRoute(card *v1alpha1.AgentCard, gatewayName, gatewayNamespace stri
 	return route
 }
 
+// BuildDiscoveredAgentCard constructs an AgentCard for a discovered agent workload.
+// The card is named after the Deployment and owned by it, so deleting the
+// workload garbage-collects the card and everything generated from it.
+// Labels listed in propagateLabels are copied from the Deployment, falling back
+// to its pod template, so that AgentPolicy selectors can match on them.
+func BuildDiscoveredAgentCard(deploy *appsv1.Deployment, spec v1alpha1.AgentCardSpec, propagateLabels []string) *v1alpha1.AgentCard {
+	labels := map[string]string{
+		labelManagedBy:      managedByValue,
+		labelDiscoveredFrom: deploy.Name,
+	}
+	for _, key := range propagateLabels {
+		if v, ok := deploy.Labels[key]; ok {
+			labels[key] = v
+		} else if v, ok := deploy.Spec.Template.Labels[key]; ok {
+			labels[key] = v
+		}
+	}
+
+	card := &v1alpha1.AgentCard{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "kagenti.com/v1alpha1",
+			Kind:       "AgentCard",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      deploy.Name,
+			Namespace: deploy.Namespace,
+			Labels:    labels,
+		},
+		Spec: spec,
+	}
+
+	setOwnerRef(&card.ObjectMeta, &deploy.ObjectMeta, schema.GroupVersionKind{
+		Group:   "apps",
+		Version: "v1",
+		Kind:    "Deployment",
+	})
+
+	return card
+}
+
 // resolveServiceAccount expands a short ServiceAccount name to a fully qualified
 // system:serviceaccount:{namespace}:{name} format. If the value already contains
 // a slash (namespace/name), the namespace part is used. Otherwise the policy's
@@ -127,28 +184,126 @@ func BuildHTTPRoute(card *v1alpha1.AgentCard, gatewayName, gatewayNamespace stri
 func resolveServiceAccount(name, policyNamespace string) string {
 	if strings.Contains(name, "/") {
 		parts := strings.SplitN(name, "/", 2)
-		return fmt.Sprintf("system:serviceaccount:%s:%s", parts[0], parts[1])
+		return fmt.Sprintf("%s%s:%s", sidecarconfig.ServiceAccountPrefix, parts[0], parts[1])
 	}
-	return fmt.Sprintf("system:serviceaccount:%s:%s", policyNamespace, name)
+	return fmt.Sprintf("%s%s:%s", sidecarconfig.ServiceAccountPrefix, policyNamespace, name)
+}
+
+// errNoIngressAuthentication is returned for an ingress policy that names no way to
+// authenticate callers.
+var errNoIngressAuthentication = errors.New("ingress policy configures no authentication: set spec.ingress.issuers, kubernetesTokenReview or apiKey, or the controller's --default-jwt-issuer-url")
+
+// ValidateIngress reports whether the policy's ingress rules can be rendered into an
+// AuthPolicy with the given default issuer. The admission webhook rejects policies that
+// fail it, and the reconciler denies all traffic for any that get through.
+func ValidateIngress(policy *v1alpha1.AgentPolicy, defaultIssuer *v1alpha1.JWTIssuer) error {
+	ingress := policy.Spec.Ingress
+	if ingress == nil {
+		return nil
+	}
+	if len(ingress.Issuers) == 0 && ingress.KubernetesTokenReview == nil && ingress.APIKey == nil && defaultIssuer == nil {
+		return errNoIngressAuthentication
+	}
+	if _, err := claimPatterns(ingress.ClaimPredicates); err != nil {
+		return err
+	}
+	return nil
 }
 
 // BuildAuthPolicy constructs a Kuadrant AuthPolicy (unstructured) for a given
 // AgentPolicy and AgentCard. It targets the specified HTTPRoute and configures
-// JWT authentication along with pattern-matching authorization based on
-// allowed ServiceAccounts from the ingress policy.
-func BuildAuthPolicy(policy *v1alpha1.AgentPolicy, card *v1alpha1.AgentCard, httpRouteName string) *unstructured.Unstructured {
-	// Build authorization predicates from allowed agents (ServiceAccount references).
-	var predicates []interface{}
+// JWT authentication for the ingress policy's issuers, or defaultIssuer when it
+// configures no authentication at all, along with pattern-matching authorization
+// based on allowed ServiceAccounts from the ingress policy.
+func BuildAuthPolicy(policy *v1alpha1.AgentPolicy, card *v1alpha1.AgentCard, httpRouteName string, defaultIssuer *v1alpha1.JWTIssuer) (*unstructured.Unstructured, error) {
+	var issuers []v1alpha1.JWTIssuer
+	var tokenReview *v1alpha1.KubernetesTokenReview
+	var apiKey *v1alpha1.APIKeyAuthentication
 	if policy.Spec.Ingress != nil {
-		for _, agent := range policy.Spec.Ingress.AllowedAgents {
-			predicates = append(predicates, map[string]interface{}{
-				"selector": "auth.identity.sub",
-				"operator": "eq",
-				"value":    resolveServiceAccount(agent, policy.Namespace),
-			})
+		issuers = policy.Spec.Ingress.Issuers
+		tokenReview = policy.Spec.Ingress.KubernetesTokenReview
+		apiKey = policy.Spec.Ingress.APIKey
+	}
+	// The default issuer stands in only for a policy that names no way to authenticate,
+	// so that choosing kubernetesTokenReview or apiKey does not also admit its tokens.
+	if len(issuers) == 0 && tokenReview == nil && apiKey == nil && defaultIssuer != nil {
+		issuers = []v1alpha1.JWTIssuer{*defaultIssuer}
+	}
+	if len(issuers) == 0 && tokenReview == nil && apiKey == nil {
+		return nil, errNoIngressAuthentication
+	}
+	authentication, audiencePatterns := jwtAuthentication(issuers)
+	if tokenReview != nil {
+		authentication["kubernetes-tokenreview"] = tokenReviewAuthentication(tokenReview, card)
+	}
+	if apiKey != nil {
+		authentication["api-key"] = apiKeyAuthentication(apiKey, policy)
+	}
+
+	authorization := map[string]interface{}{}
+	if predicates, ok := callerPredicates(policy); ok {
+		authorization["agent-access"] = map[string]interface{}{
+			"patternMatching": map[string]interface{}{
+				"patterns": []interface{}{
+					map[string]interface{}{"any": predicates},
+				},
+			},
+		}
+	}
+	if policy.Spec.Ingress != nil && len(policy.Spec.Ingress.ClaimPredicates) > 0 {
+		patterns, err := claimPatterns(policy.Spec.Ingress.ClaimPredicates)
+		if err != nil {
+			return nil, err
+		}
+		authorization["claim-predicates"] = map[string]interface{}{
+			"patternMatching": map[string]interface{}{
+				"patterns": patterns,
+			},
+		}
+	}
+	if apiKey != nil {
+		authorization["api-key-namespace"] = map[string]interface{}{
+			"patternMatching": map[string]interface{}{
+				"patterns": []interface{}{
+					map[string]interface{}{"predicate": apiKeyNamespacePredicate(policy)},
+				},
+			},
+		}
+	}
+	if len(audiencePatterns) > 0 {
+		authorization["audience"] = map[string]interface{}{
+			"patternMatching": map[string]interface{}{
+				"patterns": audiencePatterns,
+			},
 		}
 	}
 
+	return newAuthPolicy(policy, card, httpRouteName, map[string]interface{}{
+		"authentication": authentication,
+		"authorization":  authorization,
+	}), nil
+}
+
+// BuildDenyAllAuthPolicy creates an AuthPolicy that rejects every request to the card's
+// HTTPRoute. It replaces the AuthPolicy of a policy whose ingress rules cannot be
+// rendered, so that a broken policy closes the route instead of leaving it open or
+// leaving a stale AuthPolicy in force.
+func BuildDenyAllAuthPolicy(policy *v1alpha1.AgentPolicy, card *v1alpha1.AgentCard, httpRouteName string) *unstructured.Unstructured {
+	return newAuthPolicy(policy, card, httpRouteName, map[string]interface{}{
+		"authorization": map[string]interface{}{
+			"deny-all": map[string]interface{}{
+				"patternMatching": map[string]interface{}{
+					"patterns": []interface{}{
+						map[string]interface{}{"predicate": "false"},
+					},
+				},
+			},
+		},
+	})
+}
+
+// newAuthPolicy wraps rules in an AuthPolicy targeting the card's HTTPRoute.
+func newAuthPolicy(policy *v1alpha1.AgentPolicy, card *v1alpha1.AgentCard, httpRouteName string, rules map[string]interface{}) *unstructured.Unstructured {
 	authPolicy := &unstructured.Unstructured{
 		Object: map[string]interface{}{
 			"apiVersion": "kuadrant.io/v1",
@@ -164,22 +319,7 @@ func BuildAuthPolicy(policy *v1alpha1.AgentPolicy, card *v1alpha1.AgentCard, htt
 					"kind":  "HTTPRoute",
 					"name":  httpRouteName,
 				},
-				"rules": map[string]interface{}{
-					"authentication": map[string]interface{}{
-						"jwt-auth": map[string]interface{}{
-							"jwt": map[string]interface{}{
-								"issuerUrl": "https://issuer.example.com",
-							},
-						},
-					},
-					"authorization": map[string]interface{}{
-						"agent-access": map[string]interface{}{
-							"patternMatching": map[string]interface{}{
-								"patterns": predicates,
-							},
-						},
-					},
-				},
+				"rules": rules,
 			},
 		},
 	}
@@ -193,6 +333,197 @@ func BuildAuthPolicy(policy *v1alpha1.AgentPolicy, card *v1alpha1.AgentCard, htt
 	return authPolicy
 }
 
+// callerPredicates builds the predicates of the agent-access rule, any one of which
+// admits a caller: a sub equal to one of the allowed agents (ServiceAccount
+// references), or a user claim matching one of the allowed users. A "*" user admits
+// any caller that is not a ServiceAccount, so it does not lift the allowedAgents
+// restriction. It returns false when the policy names no callers at all.
+func callerPredicates(policy *v1alpha1.AgentPolicy) ([]interface{}, bool) {
+	ingress := policy.Spec.Ingress
+	if ingress == nil {
+		return nil, false
+	}
+
+	var predicates []interface{}
+	for _, agent := range ingress.AllowedAgents {
+		predicates = append(predicates, map[string]interface{}{
+			"selector": "auth.identity.sub",
+			"operator": "eq",
+			"value":    resolveServiceAccount(agent, policy.Namespace),
+		})
+	}
+	for _, user := range ingress.AllowedUsers {
+		if user == "*" {
+			predicates = append(predicates, map[string]interface{}{
+				"predicate": anyUserPredicate,
+			})
+			continue
+		}
+		for _, claim := range userClaims(ingress) {
+			predicates = append(predicates, map[string]interface{}{
+				"predicate": userPredicate(claim, user),
+			})
+		}
+	}
+	return predicates, len(predicates) > 0
+}
+
+// anyUserPredicate holds for any caller whose sub is not a ServiceAccount.
+var anyUserPredicate = fmt.Sprintf("!has(auth.identity.sub) || !auth.identity.sub.startsWith(%s)", strconv.Quote(sidecarconfig.ServiceAccountPrefix))
+
+// claimPatterns renders claim predicates as patterns on the caller's identity, all of
+// which must hold.
+func claimPatterns(predicates []v1alpha1.ClaimPredicate) ([]interface{}, error) {
+	patterns := make([]interface{}, 0, len(predicates))
+	for i, p := range predicates {
+		if p.Operator == "matches" {
+			if _, err := regexp.Compile(p.Value); err != nil {
+				return nil, fmt.Errorf("invalid claimPredicates[%d] pattern: %w", i, err)
+			}
+		}
+		patterns = append(patterns, map[string]interface{}{
+			"selector": "auth.identity." + p.Selector,
+			"operator": p.Operator,
+			"value":    p.Value,
+		})
+	}
+	return patterns, nil
+}
+
+// userClaims returns the claims allowed users are matched against.
+func userClaims(ingress *v1alpha1.IngressPolicy) []string {
+	if len(ingress.UserClaims) == 0 {
+		return []string{"sub"}
+	}
+	return ingress.UserClaims
+}
+
+// userPredicate returns a CEL predicate that holds when the token claim equals user, or
+// starts with the prefix before a trailing "*". A list claim such as groups matches if
+// any of its values does.
+func userPredicate(claim, user string) string {
+	ref := "auth.identity." + claim
+	match := func(v string) string { return fmt.Sprintf("%s == %s", v, strconv.Quote(user)) }
+	if prefix, ok := strings.CutSuffix(user, "*"); ok {
+		match = func(v string) string { return fmt.Sprintf("%s.startsWith(%s)", v, strconv.Quote(prefix)) }
+	}
+	return fmt.Sprintf("has(%s) && (type(%s) == list ? %s.exists(v, %s) : %s)", ref, ref, ref, match("v"), match(ref))
+}
+
+// tokenReviewAuthentication renders a kubernetesTokenReview authentication entry. The
+// TokenReview user is copied onto sub and groups, so that allowed agents and users
+// match the same claims as they do for JWTs.
+func tokenReviewAuthentication(tokenReview *v1alpha1.KubernetesTokenReview, card *v1alpha1.AgentCard) map[string]interface{} {
+	audiences := tokenReview.Audiences
+	if len(audiences) == 0 {
+		audiences = []string{card.Name}
+	}
+	return map[string]interface{}{
+		"kubernetesTokenReview": map[string]interface{}{
+			"audiences": stringsToInterfaces(audiences),
+		},
+		"overrides": map[string]interface{}{
+			"sub": map[string]interface{}{
+				"selector": "auth.identity.user.username",
+			},
+			"groups": map[string]interface{}{
+				"selector": "auth.identity.user.groups",
+			},
+		},
+	}
+}
+
+// apiKeyAuthentication renders an apiKey authentication entry accepting the keys in the
+// Secrets selected for the policy, sent as "Authorization: APIKEY <key>". The Secret
+// name becomes the caller's sub, so each key is admitted by allowedUsers like a user.
+func apiKeyAuthentication(apiKey *v1alpha1.APIKeyAuthentication, policy *v1alpha1.AgentPolicy) map[string]interface{} {
+	matchLabels := map[string]string{labelAPIKeyPolicy: policy.Name}
+	if len(apiKey.MatchLabels) > 0 {
+		matchLabels = maps.Clone(apiKey.MatchLabels)
+	}
+	matchLabels[labelAuthorinoManagedBy] = authorinoManagedByValue
+	return map[string]interface{}{
+		"apiKey": map[string]interface{}{
+			"selector": map[string]interface{}{
+				"matchLabels": labelsToUnstructured(matchLabels),
+			},
+			// The AuthConfig lives in Kuadrant's namespace, not the policy's, so Secrets are
+			// looked up cluster-wide and confined to the policy's namespace by
+			// apiKeyNamespacePredicate.
+			"allNamespaces": true,
+		},
+		"credentials": map[string]interface{}{
+			"authorizationHeader": map[string]interface{}{
+				"prefix": "APIKEY",
+			},
+		},
+		"overrides": map[string]interface{}{
+			"sub": map[string]interface{}{
+				"selector": "auth.identity.metadata.name",
+			},
+		},
+	}
+}
+
+// apiKeyNamespacePredicate returns a CEL predicate admitting API keys only from Secrets
+// in the policy's namespace, so that labelling a Secret elsewhere grants no access.
+// Identities other than API keys carry no metadata and pass.
+func apiKeyNamespacePredicate(policy *v1alpha1.AgentPolicy) string {
+	return fmt.Sprintf("!has(auth.identity.metadata) || auth.identity.metadata.namespace == %s", strconv.Quote(policy.Namespace))
+}
+
+// jwtAuthentication renders each issuer as a jwt authentication entry named
+// jwt-{name}. Claim mappings become identity overrides. It also returns one
+// pattern per issuer with audiences, which holds that issuer's tokens to them
+// and passes tokens from any other issuer.
+func jwtAuthentication(issuers []v1alpha1.JWTIssuer) (map[string]interface{}, []interface{}) {
+	authentication := map[string]interface{}{}
+	var audiencePatterns []interface{}
+	for _, issuer := range issuers {
+		jwt := map[string]interface{}{
+			"issuerUrl": issuer.IssuerURL,
+		}
+		if issuer.JWKSURL != "" {
+			jwt["jwksUrl"] = issuer.JWKSURL
+		}
+		entry := map[string]interface{}{
+			"jwt": jwt,
+		}
+		if len(issuer.ClaimMapping) > 0 {
+			overrides := map[string]interface{}{}
+			for claim, from := range issuer.ClaimMapping {
+				overrides[claim] = map[string]interface{}{
+					"selector": "auth.identity." + from,
+				}
+			}
+			entry["overrides"] = overrides
+		}
+		authentication["jwt-"+issuer.Name] = entry
+
+		if len(issuer.Audiences) == 0 {
+			continue
+		}
+		anyOf := []interface{}{
+			map[string]interface{}{
+				"selector": "auth.identity.iss",
+				"operator": "neq",
+				"value":    issuer.IssuerURL,
+			},
+		}
+		for _, aud := range issuer.Audiences {
+			anyOf = append(anyOf, map[string]interface{}{
+				"selector": "auth.identity.aud",
+				"operator": "incl",
+				"value":    aud,
+			})
+		}
+		audiencePatterns = append(audiencePatterns, map[string]interface{}{
+			"any": anyOf,
+		})
+	}
+	return authentication, audiencePatterns
+}
+
 // BuildRateLimitPolicy constructs a Kuadrant RateLimitPolicy (unstructured) for
 // a given AgentPolicy and AgentCard. It targets the specified HTTPRoute and
 // configures rate limits based on the policy's RequestsPerMinute setting.
@@ -240,60 +571,73 @@ func BuildRateLimitPolicy(policy *v1alpha1.AgentPolicy, card *v1alpha1.AgentCard
 	return rlp
 }
 
-// sidecarConfig is the internal structure serialized to YAML for the sidecar ConfigMap.
-type sidecarConfig struct {
-	Gateway       sidecarGateway       `json:"gateway"`
-	AllowedAgents []string             `json:"allowedAgents"`
-	External      sidecarExternal      `json:"external"`
+// sidecarConfigMapName returns the name of the sidecar ConfigMap generated for an AgentCard.
+func sidecarConfigMapName(cardName string) string {
+	return "sidecar-config-" + cardName
 }
 
-type sidecarGateway struct {
-	Host string `json:"host"`
-	Mode string `json:"mode"`
+// secretRefUse is a Secret key referenced by a field of an AgentPolicy. Type, when set,
+// is the Secret type the field requires.
+type secretRefUse struct {
+	Field string
+	Ref   v1alpha1.SecretKeyRef
+	Type  corev1.SecretType
 }
 
-type sidecarExternal struct {
-	Rules       []sidecarExternalRule `json:"rules"`
-	DefaultMode string               `json:"defaultMode"`
+// policySecretRefs returns the Secret keys an AgentPolicy's external policy references.
+func policySecretRefs(policy *v1alpha1.AgentPolicy) []secretRefUse {
+	ext := policy.Spec.External
+	if ext == nil {
+		return nil
+	}
+
+	var refs []secretRefUse
+	for i, rule := range ext.Rules {
+		if rule.SecretRef != nil {
+			refs = append(refs, secretRefUse{Field: fmt.Sprintf("spec.external.rules[%d].secretRef", i), Ref: *rule.SecretRef})
+		}
+		if rule.ClientCertSecret != "" {
+			field := fmt.Sprintf("spec.external.rules[%d].clientCertSecret", i)
+			for _, key := range []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey} {
+				refs = append(refs, secretRefUse{Field: field, Ref: v1alpha1.SecretKeyRef{Name: rule.ClientCertSecret, Key: key}, Type: corev1.SecretTypeTLS})
+			}
+		}
+		if rule.CACertRef != nil {
+			refs = append(refs, secretRefUse{Field: fmt.Sprintf("spec.external.rules[%d].caCertRef", i), Ref: *rule.CACertRef})
+		}
+	}
+	if ext.IdentityProvider != nil && ext.IdentityProvider.ClientSecretRef != nil {
+		refs = append(refs, secretRefUse{Field: "spec.external.identityProvider.clientSecretRef", Ref: *ext.IdentityProvider.ClientSecretRef})
+	}
+	if ext.Vault != nil && ext.Vault.CACertRef != nil {
+		refs = append(refs, secretRefUse{Field: "spec.external.vault.caCertRef", Ref: *ext.Vault.CACertRef})
+	}
+	if ext.TLSInterception != nil {
+		for _, key := range []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey} {
+			refs = append(refs, secretRefUse{
+				Field: "spec.external.tlsInterception.caSecret",
+				Ref:   v1alpha1.SecretKeyRef{Name: ext.TLSInterception.CASecret, Key: key},
+				Type:  corev1.SecretTypeTLS,
+			})
+		}
+	}
+	return refs
 }
 
-type sidecarExternalRule struct {
-	Host         string   `json:"host"`
-	Mode         string   `json:"mode"`
-	VaultPath    string   `json:"vaultPath,omitempty"`
-	Audience     string   `json:"audience,omitempty"`
-	Scopes       []string `json:"scopes,omitempty"`
-	Header       string   `json:"header,omitempty"`
-	HeaderPrefix string   `json:"headerPrefix,omitempty"`
+// sidecarSecretPath returns where a Secret key is mounted in the sidecar container.
+func sidecarSecretPath(ref v1alpha1.SecretKeyRef) string {
+	return path.Join(sidecarSecretsDir, ref.Name, ref.Key)
 }
 
 // BuildSidecarConfigMap constructs a ConfigMap containing the sidecar proxy
 // configuration derived from the AgentPolicy and AgentCard. The configuration
-// is YAML-serialized under the "config.yaml" key.
+// is YAML-serialized under the "config.yaml" key. Referenced Secrets are listed in
+// the kagenti.com/sidecar-secrets annotation so the sidecar injector can mount them.
 func BuildSidecarConfigMap(policy *v1alpha1.AgentPolicy, card *v1alpha1.AgentCard) (*corev1.ConfigMap, error) {
-	cfg := sidecarConfig{
-		Gateway: sidecarGateway{
-			Host: fmt.Sprintf("agent-gateway.%s.svc.cluster.local", card.Namespace),
-			Mode: "passthrough",
-		},
-		AllowedAgents: policy.Spec.Agents,
+	cfg := buildSidecarConfig(policy, card)
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid sidecar config: %w", err)
 	}
-
-	if policy.Spec.External != nil {
-		cfg.External.DefaultMode = policy.Spec.External.DefaultMode
-		for _, r := range policy.Spec.External.Rules {
-			cfg.External.Rules = append(cfg.External.Rules, sidecarExternalRule{
-				Host:         r.Host,
-				Mode:         r.Mode,
-				VaultPath:    r.VaultPath,
-				Audience:     r.Audience,
-				Scopes:       r.Scopes,
-				Header:       r.Header,
-				HeaderPrefix: r.HeaderPrefix,
-			})
-		}
-	}
-
 	data, err := yaml.Marshal(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal sidecar config: %w", err)
@@ -305,15 +649,26 @@ func BuildSidecarConfigMap(policy *v1alpha1.AgentPolicy, card *v1alpha1.AgentCar
 			Kind:       "ConfigMap",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "sidecar-config-" + card.Name,
+			Name:      sidecarConfigMapName(card.Name),
 			Namespace: card.Namespace,
 			Labels:    commonLabels(card.Name),
 		},
 		Data: map[string]string{
-			"config.yaml": string(data),
+			sidecarConfigKey: string(data),
 		},
 	}
 
+	var secretNames []string
+	for _, use := range policySecretRefs(policy) {
+		if !slices.Contains(secretNames, use.Ref.Name) {
+			secretNames = append(secretNames, use.Ref.Name)
+		}
+	}
+	if len(secretNames) > 0 {
+		sort.Strings(secretNames)
+		cm.Annotations = map[string]string{annotationSidecarSecrets: strings.Join(secretNames, ",")}
+	}
+
 	setOwnerRef(&cm.ObjectMeta, &policy.ObjectMeta, schema.GroupVersionKind{
 		Group:   "kagenti.com",
 		Version: "v1alpha1",
@@ -323,6 +678,136 @@ func BuildSidecarConfigMap(policy *v1alpha1.AgentPolicy, card *v1alpha1.AgentCar
 	return cm, nil
 }
 
+// buildSidecarConfig derives the sidecar configuration for one of the policy's cards.
+func buildSidecarConfig(policy *v1alpha1.AgentPolicy, card *v1alpha1.AgentCard) sidecarconfig.Config {
+	cfg := sidecarconfig.Config{
+		APIVersion: sidecarconfig.APIVersion,
+		Agent:      card.Name,
+		Gateway: sidecarconfig.Gateway{
+			Host: fmt.Sprintf("agent-gateway.%s.svc.cluster.local", card.Namespace),
+			Mode: sidecarconfig.ModePassthrough,
+		},
+		AllowedAgents: policy.Spec.Agents,
+	}
+
+	// Without an external policy the sidecar only fronts the agent port, so egress is
+	// left open.
+	cfg.External.DefaultMode = sidecarconfig.ModePassthrough
+	if policy.Spec.External != nil {
+		cfg.External.DefaultMode = policy.Spec.External.DefaultMode
+		for _, r := range policy.Spec.External.Rules {
+			var ports []int
+			for _, port := range r.Ports {
+				ports = append(ports, int(port))
+			}
+			var rateLimit *sidecarconfig.RateLimit
+			if r.RateLimit != nil {
+				rateLimit = &sidecarconfig.RateLimit{
+					Requests: int(r.RateLimit.Requests),
+					Burst:    int(r.RateLimit.Burst),
+				}
+				if r.RateLimit.Window.Duration > 0 {
+					rateLimit.Window = r.RateLimit.Window.Duration.String()
+				}
+			}
+			var secretFile, clientCertFile, clientKeyFile, caCertFile string
+			if r.SecretRef != nil {
+				secretFile = sidecarSecretPath(*r.SecretRef)
+			}
+			if r.ClientCertSecret != "" {
+				clientCertFile = sidecarSecretPath(v1alpha1.SecretKeyRef{Name: r.ClientCertSecret, Key: corev1.TLSCertKey})
+				clientKeyFile = sidecarSecretPath(v1alpha1.SecretKeyRef{Name: r.ClientCertSecret, Key: corev1.TLSPrivateKeyKey})
+			}
+			if r.CACertRef != nil {
+				caCertFile = sidecarSecretPath(*r.CACertRef)
+			}
+			cfg.External.Rules = append(cfg.External.Rules, sidecarconfig.ExternalRule{
+				Host:           r.Host,
+				Ports:          ports,
+				Paths:          r.Paths,
+				Mode:           r.Mode,
+				AllowedMethods: r.AllowedMethods,
+				AllowedPaths:   r.AllowedPaths,
+				VaultPath:      r.VaultPath,
+				SecretFile:     secretFile,
+				ClientCertFile: clientCertFile,
+				ClientKeyFile:  clientKeyFile,
+				CACertFile:     caCertFile,
+				Audience:       r.Audience,
+				Scopes:         r.Scopes,
+				Header:         r.Header,
+				HeaderPrefix:   r.HeaderPrefix,
+				RateLimit:      rateLimit,
+			})
+		}
+
+		if idp := policy.Spec.External.IdentityProvider; idp != nil {
+			cfg.IdentityProvider = &sidecarconfig.IdentityProvider{
+				TokenEndpoint: idp.TokenEndpoint,
+				ClientID:      idp.ClientID,
+			}
+			if idp.ClientSecretRef != nil {
+				cfg.IdentityProvider.ClientSecretFile = sidecarSecretPath(*idp.ClientSecretRef)
+			}
+		}
+		if v := policy.Spec.External.Vault; v != nil {
+			cfg.Vault = &sidecarconfig.Vault{
+				Address:   v.Address,
+				Role:      v.Role,
+				AuthMount: v.AuthMount,
+			}
+			if v.CACertRef != nil {
+				cfg.Vault.CACertFile = sidecarSecretPath(*v.CACertRef)
+			}
+		}
+		if ti := policy.Spec.External.TLSInterception; ti != nil {
+			cfg.TLSInterception = &sidecarconfig.TLSInterception{
+				CACertFile: sidecarSecretPath(v1alpha1.SecretKeyRef{Name: ti.CASecret, Key: corev1.TLSCertKey}),
+				CAKeyFile:  sidecarSecretPath(v1alpha1.SecretKeyRef{Name: ti.CASecret, Key: corev1.TLSPrivateKeyKey}),
+			}
+		}
+	}
+
+	if ingress := policy.Spec.Ingress; ingress != nil && ingress.SidecarValidation != nil {
+		v := ingress.SidecarValidation
+		audiences := v.Audiences
+		if len(audiences) == 0 {
+			audiences = []string{card.Name}
+		}
+		subjects := []string{}
+		for _, agent := range ingress.AllowedAgents {
+			subjects = append(subjects, resolveServiceAccount(agent, policy.Namespace))
+		}
+		cfg.Inbound = &sidecarconfig.Inbound{
+			UpstreamPort:    int(card.Spec.ServicePort),
+			Issuer:          v.IssuerURL,
+			JWKSURL:         v.JWKSURL,
+			Audiences:       audiences,
+			AllowedSubjects: subjects,
+			AllowedUsers:    ingress.AllowedUsers,
+			UserClaims:      ingress.UserClaims,
+		}
+	}
+
+	return cfg
+}
+
+// ValidateSidecarConfig checks that the sidecar configuration generated from the
+// policy passes sidecarconfig validation, as BuildSidecarConfigMap requires. The cards
+// the policy selects are not known at admission, so their name and port are
+// placeholders.
+func ValidateSidecarConfig(policy *v1alpha1.AgentPolicy) error {
+	card := &v1alpha1.AgentCard{
+		ObjectMeta: metav1.ObjectMeta{Name: policy.Name, Namespace: policy.Namespace},
+		Spec:       v1alpha1.AgentCardSpec{ServicePort: 8080},
+	}
+	cfg := buildSidecarConfig(policy, card)
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid sidecar config: %w", err)
+	}
+	return nil
+}
+
 // BuildMCPServerRegistration constructs an MCPServerRegistration (unstructured)
 // for a given AgentCard. It targets the specified HTTPRoute and configures the
 // MCP tool prefix and path.
@@ -385,6 +870,15 @@ func labelsToUnstructured(labels map[string]string) map[string]interface{} {
 	return result
 }
 
+// stringsToInterfaces converts a string slice to an unstructured-compatible slice.
+func stringsToInterfaces(values []string) []interface{} {
+	result := make([]interface{}, len(values))
+	for i, v := range values {
+		result[i] = v
+	}
+	return result
+}
+
 // BuildNetworkPolicy constructs a Kubernetes NetworkPolicy for egress enforcement.
 // When the external defaultMode is "deny", the NetworkPolicy denies all egress
 // except DNS (port 53) and the cluster gateway. The sidecar proxy provides
@@ -1,12 +1,19 @@
 package controller
 
 import (
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
 
 	v1alpha1 "github.com/agentoperations/agent-access-control/api/v1alpha1"
+	"github.com/agentoperations/agent-access-control/pkg/sidecarconfig"
 )
 
 func testAgentCard(name, namespace string) *v1alpha1.AgentCard {
@@ -40,6 +47,9 @@ func testAgentPolicy(name, namespace string) *v1alpha1.AgentPolicy {
 			},
 			Ingress: &v1alpha1.IngressPolicy{
 				AllowedAgents: []string{"agent-a", "agent-b"},
+				Issuers: []v1alpha1.JWTIssuer{
+					{Name: "corp", IssuerURL: "https://issuer.example.com"},
+				},
 			},
 			RateLimit: &v1alpha1.RateLimitSpec{
 				RequestsPerMinute: 100,
@@ -147,11 +157,78 @@ func TestBuildHTTPRoute_DefaultPort(t *testing.T) {
 	}
 }
 
+func TestBuildDiscoveredAgentCard(t *testing.T) {
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "weather",
+			Namespace: "default",
+			UID:       types.UID("test-uid-deploy"),
+			Labels:    map[string]string{labelAgent: "true", "tier": "premium", "domain": "weather"},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"tier": "standard", "team": "forecasting"},
+				},
+			},
+		},
+	}
+	spec := testAgentCard("weather", "default").Spec
+
+	card := BuildDiscoveredAgentCard(deploy, spec, []string{"tier", "team"})
+
+	t.Run("metadata", func(t *testing.T) {
+		if card.Name != "weather" || card.Namespace != "default" {
+			t.Errorf("expected default/weather, got %s/%s", card.Namespace, card.Name)
+		}
+		if card.Labels[labelManagedBy] != managedByValue {
+			t.Errorf("expected managed-by label")
+		}
+		if card.Labels[labelDiscoveredFrom] != "weather" {
+			t.Errorf("expected discovered-from label 'weather', got %q", card.Labels[labelDiscoveredFrom])
+		}
+	})
+
+	t.Run("propagated_labels", func(t *testing.T) {
+		if card.Labels["tier"] != "premium" {
+			t.Errorf("expected Deployment label tier=premium to win, got %q", card.Labels["tier"])
+		}
+		if card.Labels["team"] != "forecasting" {
+			t.Errorf("expected pod template label team=forecasting, got %q", card.Labels["team"])
+		}
+		if _, ok := card.Labels["domain"]; ok {
+			t.Errorf("expected label outside the allowlist not to be propagated")
+		}
+		if _, ok := card.Labels[labelAgent]; ok {
+			t.Errorf("expected %s not to be propagated", labelAgent)
+		}
+	})
+
+	t.Run("spec", func(t *testing.T) {
+		if card.Spec.ServicePort != 9090 || len(card.Spec.Skills) != 1 {
+			t.Errorf("expected spec to be copied, got %+v", card.Spec)
+		}
+	})
+
+	t.Run("owner_reference", func(t *testing.T) {
+		if len(card.OwnerReferences) != 1 {
+			t.Fatalf("expected 1 owner ref, got %d", len(card.OwnerReferences))
+		}
+		ref := card.OwnerReferences[0]
+		if ref.APIVersion != "apps/v1" || ref.Kind != "Deployment" || ref.Name != "weather" {
+			t.Errorf("expected owner apps/v1 Deployment weather, got %s %s %s", ref.APIVersion, ref.Kind, ref.Name)
+		}
+	})
+}
+
 func TestBuildAuthPolicy(t *testing.T) {
 	card := testAgentCard("weather", "default")
 	policy := testAgentPolicy("premium-policy", "default")
 
-	authPolicy := BuildAuthPolicy(policy, card, "agent-weather")
+	authPolicy, err := BuildAuthPolicy(policy, card, "agent-weather", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 
 	t.Run("metadata", func(t *testing.T) {
 		if authPolicy.GetName() != "ap-weather" {
@@ -278,8 +355,18 @@ func TestBuildSidecarConfigMap(t *testing.T) {
 		if !ok {
 			t.Fatal("expected 'config.yaml' key in ConfigMap data")
 		}
-		if len(data) == 0 {
-			t.Fatal("expected non-empty config.yaml")
+		cfg, err := sidecarconfig.Parse([]byte(data))
+		if err != nil {
+			t.Fatalf("expected config.yaml to parse: %v", err)
+		}
+		if cfg.APIVersion != sidecarconfig.APIVersion {
+			t.Errorf("expected apiVersion %q, got %q", sidecarconfig.APIVersion, cfg.APIVersion)
+		}
+		if cfg.Agent != "weather" {
+			t.Errorf("expected agent 'weather', got %q", cfg.Agent)
+		}
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("expected generated config to validate: %v", err)
 		}
 	})
 
@@ -293,6 +380,237 @@ func TestBuildSidecarConfigMap(t *testing.T) {
 	})
 }
 
+func TestBuildSidecarConfigMap_CredentialProviders(t *testing.T) {
+	card := testAgentCard("weather", "default")
+	policy := testAgentPolicy("premium-policy", "default")
+	policy.Spec.External.IdentityProvider = &v1alpha1.IdentityProviderSpec{
+		TokenEndpoint:   "https://keycloak.example.com/realms/agents/protocol/openid-connect/token",
+		ClientID:        "agent-sidecar",
+		ClientSecretRef: &v1alpha1.SecretKeyRef{Name: "idp-client", Key: "client-secret"},
+	}
+	policy.Spec.External.Vault = &v1alpha1.VaultSpec{
+		Address:   "https://vault.vault.svc:8200",
+		Role:      "weather-agent",
+		AuthMount: "kubernetes",
+		CACertRef: &v1alpha1.SecretKeyRef{Name: "vault-ca", Key: "ca.crt"},
+	}
+
+	cm, err := BuildSidecarConfigMap(policy, card)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var cfg sidecarconfig.Config
+	if err := yaml.Unmarshal([]byte(cm.Data[sidecarConfigKey]), &cfg); err != nil {
+		t.Fatalf("failed to parse config.yaml: %v", err)
+	}
+	if cfg.IdentityProvider == nil || cfg.IdentityProvider.ClientID != "agent-sidecar" {
+		t.Fatalf("expected identityProvider block, got %+v", cfg.IdentityProvider)
+	}
+	if cfg.IdentityProvider.ClientSecretFile != "/var/run/agent-sidecar/secrets/idp-client/client-secret" {
+		t.Errorf("unexpected clientSecretFile %q", cfg.IdentityProvider.ClientSecretFile)
+	}
+	if cfg.Vault == nil || cfg.Vault.Role != "weather-agent" || cfg.Vault.CACertFile != "/var/run/agent-sidecar/secrets/vault-ca/ca.crt" {
+		t.Errorf("unexpected vault block %+v", cfg.Vault)
+	}
+	if got := cm.Annotations[annotationSidecarSecrets]; got != "idp-client,vault-ca" {
+		t.Errorf("expected sidecar-secrets annotation 'idp-client,vault-ca', got %q", got)
+	}
+}
+
+func TestBuildSidecarConfigMap_RuleMatching(t *testing.T) {
+	card := testAgentCard("weather", "default")
+	policy := testAgentPolicy("premium-policy", "default")
+	policy.Spec.External.Rules = append(policy.Spec.External.Rules, v1alpha1.ExternalRule{
+		Host:           "*.googleapis.com",
+		Ports:          []int32{443},
+		Paths:          []string{"/storage/v1"},
+		Mode:           "passthrough",
+		AllowedMethods: []string{"GET"},
+		AllowedPaths:   []string{"/storage/v1/b/*/o"},
+		RateLimit:      &v1alpha1.ExternalRateLimit{Requests: 100, Window: metav1.Duration{Duration: time.Hour}, Burst: 10},
+	})
+
+	cm, err := BuildSidecarConfigMap(policy, card)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg, err := sidecarconfig.Parse([]byte(cm.Data[sidecarConfigKey]))
+	if err != nil {
+		t.Fatalf("failed to parse config.yaml: %v", err)
+	}
+	rule := cfg.RuleFor(sidecarconfig.NewTarget("storage.googleapis.com", 443, "/storage/v1/b"))
+	if rule == nil || rule.Host != "*.googleapis.com" || len(rule.Ports) != 1 || rule.Ports[0] != 443 {
+		t.Errorf("expected wildcard rule with ports and paths, got %+v", rule)
+	}
+	if len(rule.AllowedMethods) != 1 || len(rule.AllowedPaths) != 1 {
+		t.Errorf("expected allowlists to be carried into the sidecar config, got %+v", rule)
+	}
+	if rl := rule.RateLimit; rl == nil || rl.Requests != 100 || rl.Window != "1h0m0s" || rl.Burst != 10 {
+		t.Errorf("expected rate limit to be carried into the sidecar config, got %+v", rl)
+	}
+}
+
+func TestBuildSidecarConfigMap_SecretRule(t *testing.T) {
+	card := testAgentCard("weather", "default")
+	policy := testAgentPolicy("premium-policy", "default")
+	policy.Spec.External.Rules = append(policy.Spec.External.Rules, v1alpha1.ExternalRule{
+		Host:      "api.openai.com",
+		Mode:      "secret",
+		SecretRef: &v1alpha1.SecretKeyRef{Name: "openai", Key: "api-key"},
+	})
+
+	cm, err := BuildSidecarConfigMap(policy, card)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg, err := sidecarconfig.Parse([]byte(cm.Data[sidecarConfigKey]))
+	if err != nil {
+		t.Fatalf("failed to parse config.yaml: %v", err)
+	}
+	rule := cfg.RuleFor(sidecarconfig.NewTarget("api.openai.com", 443, ""))
+	if rule == nil || rule.SecretFile != "/var/run/agent-sidecar/secrets/openai/api-key" {
+		t.Errorf("expected secretFile to point at the mounted Secret, got %+v", rule)
+	}
+	if got := cm.Annotations[annotationSidecarSecrets]; got != "openai" {
+		t.Errorf("expected sidecar-secrets annotation 'openai', got %q", got)
+	}
+}
+
+func TestBuildSidecarConfigMap_MTLSRule(t *testing.T) {
+	card := testAgentCard("weather", "default")
+	policy := testAgentPolicy("premium-policy", "default")
+	policy.Spec.External.Rules = append(policy.Spec.External.Rules, v1alpha1.ExternalRule{
+		Host:             "partner.example.com",
+		Mode:             "mtls",
+		ClientCertSecret: "partner-client",
+		CACertRef:        &v1alpha1.SecretKeyRef{Name: "partner-ca", Key: "ca.crt"},
+	})
+
+	cm, err := BuildSidecarConfigMap(policy, card)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg, err := sidecarconfig.Parse([]byte(cm.Data[sidecarConfigKey]))
+	if err != nil {
+		t.Fatalf("failed to parse config.yaml: %v", err)
+	}
+	rule := cfg.RuleFor(sidecarconfig.NewTarget("partner.example.com", 80, "/"))
+	if rule == nil {
+		t.Fatal("expected mtls rule")
+	}
+	if rule.ClientCertFile != "/var/run/agent-sidecar/secrets/partner-client/tls.crt" ||
+		rule.ClientKeyFile != "/var/run/agent-sidecar/secrets/partner-client/tls.key" ||
+		rule.CACertFile != "/var/run/agent-sidecar/secrets/partner-ca/ca.crt" {
+		t.Errorf("unexpected certificate paths %+v", rule)
+	}
+	if got := cm.Annotations[annotationSidecarSecrets]; got != "partner-ca,partner-client" {
+		t.Errorf("expected sidecar-secrets annotation 'partner-ca,partner-client', got %q", got)
+	}
+}
+
+func TestBuildSidecarConfigMap_TLSInterception(t *testing.T) {
+	card := testAgentCard("weather", "default")
+	policy := testAgentPolicy("premium-policy", "default")
+	policy.Spec.External.TLSInterception = &v1alpha1.TLSInterceptionSpec{CASecret: "egress-ca"}
+
+	cm, err := BuildSidecarConfigMap(policy, card)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg, err := sidecarconfig.Parse([]byte(cm.Data[sidecarConfigKey]))
+	if err != nil {
+		t.Fatalf("failed to parse config.yaml: %v", err)
+	}
+	ti := cfg.TLSInterception
+	if ti == nil {
+		t.Fatal("expected a tlsInterception block")
+	}
+	if ti.CACertFile != "/var/run/agent-sidecar/secrets/egress-ca/tls.crt" ||
+		ti.CAKeyFile != "/var/run/agent-sidecar/secrets/egress-ca/tls.key" {
+		t.Errorf("unexpected CA paths %+v", ti)
+	}
+	if got := cm.Annotations[annotationSidecarSecrets]; got != "egress-ca" {
+		t.Errorf("expected sidecar-secrets annotation 'egress-ca', got %q", got)
+	}
+}
+
+func TestBuildSidecarConfigMap_SidecarValidation(t *testing.T) {
+	card := testAgentCard("weather", "default")
+	policy := testAgentPolicy("premium-policy", "default")
+	policy.Spec.External = nil
+	policy.Spec.Ingress.AllowedAgents = []string{"orchestrator", "other-ns/planner"}
+	policy.Spec.Ingress.AllowedUsers = []string{"alice"}
+	policy.Spec.Ingress.SidecarValidation = &v1alpha1.SidecarValidation{
+		IssuerURL: "https://kubernetes.default.svc",
+	}
+
+	cm, err := BuildSidecarConfigMap(policy, card)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg, err := sidecarconfig.Parse([]byte(cm.Data[sidecarConfigKey]))
+	if err != nil {
+		t.Fatalf("failed to parse config.yaml: %v", err)
+	}
+	in := cfg.Inbound
+	if in == nil {
+		t.Fatal("expected an inbound block")
+	}
+	if in.UpstreamPort != 9090 || in.Issuer != "https://kubernetes.default.svc" {
+		t.Errorf("unexpected inbound block %+v", in)
+	}
+	if len(in.Audiences) != 1 || in.Audiences[0] != "weather" {
+		t.Errorf("expected audience to default to the card name, got %v", in.Audiences)
+	}
+	want := []string{
+		"system:serviceaccount:default:orchestrator",
+		"system:serviceaccount:other-ns:planner",
+	}
+	if len(in.AllowedSubjects) != len(want) {
+		t.Fatalf("expected subjects %v, got %v", want, in.AllowedSubjects)
+	}
+	for i := range want {
+		if in.AllowedSubjects[i] != want[i] {
+			t.Errorf("subject %d: expected %q, got %q", i, want[i], in.AllowedSubjects[i])
+		}
+	}
+	if len(in.AllowedUsers) != 1 || in.AllowedUsers[0] != "alice" || len(in.UserClaims) != 0 {
+		t.Errorf("expected users [alice] matched on the default claim, got %v on %v", in.AllowedUsers, in.UserClaims)
+	}
+	if cfg.External.DefaultMode != sidecarconfig.ModePassthrough {
+		t.Errorf("expected egress to stay open without an external policy, got %q", cfg.External.DefaultMode)
+	}
+
+	// Users matched on other claims, including "*", reach the sidecar too.
+	policy.Spec.Ingress.AllowedUsers = []string{"*", "ml-platform"}
+	policy.Spec.Ingress.UserClaims = []string{"email", "groups"}
+	cm, err = BuildSidecarConfigMap(policy, card)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg, err = sidecarconfig.Parse([]byte(cm.Data[sidecarConfigKey]))
+	if err != nil {
+		t.Fatalf("failed to parse config.yaml: %v", err)
+	}
+	if !slices.Equal(cfg.Inbound.AllowedUsers, []string{"*", "ml-platform"}) || !slices.Equal(cfg.Inbound.UserClaims, []string{"email", "groups"}) {
+		t.Errorf("expected users and claims to be passed to the sidecar, got %v on %v", cfg.Inbound.AllowedUsers, cfg.Inbound.UserClaims)
+	}
+}
+
+func TestBuildSidecarConfigMap_InvalidRule(t *testing.T) {
+	card := testAgentCard("weather", "default")
+	policy := testAgentPolicy("premium-policy", "default")
+	policy.Spec.External.Rules = append(policy.Spec.External.Rules, v1alpha1.ExternalRule{
+		Host: "api.openai.com",
+		Mode: "vault",
+	})
+
+	if _, err := BuildSidecarConfigMap(policy, card); err == nil {
+		t.Fatal("expected error for vault rule without vaultPath")
+	}
+}
+
 func TestBuildMCPServerRegistration(t *testing.T) {
 	card := testAgentCard("weather", "default")
 
@@ -414,7 +732,10 @@ func TestBuildAuthPolicy_ServiceAccountIdentity(t *testing.T) {
 	card := testAgentCard("weather", "default")
 	policy := testAgentPolicy("premium-policy", "default")
 
-	authPolicy := BuildAuthPolicy(policy, card, "agent-weather")
+	authPolicy, err := BuildAuthPolicy(policy, card, "agent-weather", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 
 	spec := authPolicy.Object["spec"].(map[string]interface{})
 	rules := spec["rules"].(map[string]interface{})
@@ -423,21 +744,346 @@ func TestBuildAuthPolicy_ServiceAccountIdentity(t *testing.T) {
 	patternMatching := agentAccess["patternMatching"].(map[string]interface{})
 	patterns := patternMatching["patterns"].([]interface{})
 
-	if len(patterns) != 2 {
-		t.Fatalf("expected 2 patterns, got %d", len(patterns))
+	if len(patterns) != 1 {
+		t.Fatalf("expected a single pattern, got %d", len(patterns))
+	}
+	anyOf := patterns[0].(map[string]interface{})["any"].([]interface{})
+	if len(anyOf) != 2 {
+		t.Fatalf("expected the allowed agents to be ORed, got %v", anyOf)
 	}
 
-	first := patterns[0].(map[string]interface{})
+	first := anyOf[0].(map[string]interface{})
 	if first["value"] != "system:serviceaccount:default:agent-a" {
 		t.Errorf("expected SA-resolved value 'system:serviceaccount:default:agent-a', got %v", first["value"])
 	}
 
-	second := patterns[1].(map[string]interface{})
+	second := anyOf[1].(map[string]interface{})
 	if second["value"] != "system:serviceaccount:default:agent-b" {
 		t.Errorf("expected SA-resolved value 'system:serviceaccount:default:agent-b', got %v", second["value"])
 	}
 }
 
+func TestBuildAuthPolicy_Issuers(t *testing.T) {
+	card := testAgentCard("weather", "default")
+	policy := testAgentPolicy("premium-policy", "default")
+	policy.Spec.Ingress.Issuers = []v1alpha1.JWTIssuer{
+		{
+			Name:      "keycloak",
+			IssuerURL: "https://keycloak.example.com/realms/agents",
+			Audiences: []string{"weather", "agents"},
+		},
+		{
+			Name:         "entra",
+			IssuerURL:    "https://login.example.com/tenant/v2.0",
+			JWKSURL:      "https://login.example.com/tenant/discovery/v2.0/keys",
+			ClaimMapping: map[string]string{"sub": "oid"},
+		},
+	}
+
+	authPolicy, err := BuildAuthPolicy(policy, card, "agent-weather", &v1alpha1.JWTIssuer{Name: "default", IssuerURL: "https://default.example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rules := authPolicy.Object["spec"].(map[string]interface{})["rules"].(map[string]interface{})
+	authn := rules["authentication"].(map[string]interface{})
+	if len(authn) != 2 {
+		t.Fatalf("expected one entry per issuer without the default, got %v", authn)
+	}
+
+	keycloak := authn["jwt-keycloak"].(map[string]interface{})["jwt"].(map[string]interface{})
+	if keycloak["issuerUrl"] != "https://keycloak.example.com/realms/agents" {
+		t.Errorf("unexpected issuerUrl %v", keycloak["issuerUrl"])
+	}
+	if _, ok := keycloak["jwksUrl"]; ok {
+		t.Error("expected jwksUrl to be omitted when unset")
+	}
+
+	entra := authn["jwt-entra"].(map[string]interface{})
+	if entra["jwt"].(map[string]interface{})["jwksUrl"] != "https://login.example.com/tenant/discovery/v2.0/keys" {
+		t.Errorf("unexpected jwt %v", entra["jwt"])
+	}
+	sub := entra["overrides"].(map[string]interface{})["sub"].(map[string]interface{})
+	if sub["selector"] != "auth.identity.oid" {
+		t.Errorf("expected sub to be mapped from oid, got %v", sub)
+	}
+
+	audience := rules["authorization"].(map[string]interface{})["audience"].(map[string]interface{})
+	patterns := audience["patternMatching"].(map[string]interface{})["patterns"].([]interface{})
+	if len(patterns) != 1 {
+		t.Fatalf("expected one audience pattern for the issuer with audiences, got %v", patterns)
+	}
+	anyOf := patterns[0].(map[string]interface{})["any"].([]interface{})
+	if len(anyOf) != 3 {
+		t.Fatalf("expected the issuer check plus one entry per audience, got %v", anyOf)
+	}
+	if iss := anyOf[0].(map[string]interface{}); iss["operator"] != "neq" || iss["value"] != "https://keycloak.example.com/realms/agents" {
+		t.Errorf("expected tokens from other issuers to pass, got %v", iss)
+	}
+	if aud := anyOf[2].(map[string]interface{}); aud["operator"] != "incl" || aud["value"] != "agents" {
+		t.Errorf("unexpected audience predicate %v", aud)
+	}
+}
+
+func TestBuildAuthPolicy_DefaultIssuer(t *testing.T) {
+	card := testAgentCard("weather", "default")
+	policy := testAgentPolicy("premium-policy", "default")
+	policy.Spec.Ingress.Issuers = nil
+
+	if _, err := BuildAuthPolicy(policy, card, "agent-weather", nil); err == nil {
+		t.Fatal("expected error without issuers or a default issuer")
+	}
+
+	authPolicy, err := BuildAuthPolicy(policy, card, "agent-weather", &v1alpha1.JWTIssuer{Name: "default", IssuerURL: "https://default.example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rules := authPolicy.Object["spec"].(map[string]interface{})["rules"].(map[string]interface{})
+	authn := rules["authentication"].(map[string]interface{})
+	jwt := authn["jwt-default"].(map[string]interface{})["jwt"].(map[string]interface{})
+	if jwt["issuerUrl"] != "https://default.example.com" {
+		t.Errorf("expected the default issuer, got %v", authn)
+	}
+	if _, ok := rules["authorization"].(map[string]interface{})["audience"]; ok {
+		t.Error("expected no audience rule when no issuer sets audiences")
+	}
+
+	// Any other authentication method replaces the default issuer rather than adding to it.
+	defaultIssuer := &v1alpha1.JWTIssuer{Name: "default", IssuerURL: "https://default.example.com"}
+	for name, ingress := range map[string]*v1alpha1.IngressPolicy{
+		"kubernetesTokenReview": {KubernetesTokenReview: &v1alpha1.KubernetesTokenReview{}},
+		"apiKey":                {APIKey: &v1alpha1.APIKeyAuthentication{}},
+	} {
+		policy.Spec.Ingress = ingress
+		authPolicy, err := BuildAuthPolicy(policy, card, "agent-weather", defaultIssuer)
+		if err != nil {
+			t.Fatalf("unexpected error with %s: %v", name, err)
+		}
+		authn := authPolicy.Object["spec"].(map[string]interface{})["rules"].(map[string]interface{})["authentication"].(map[string]interface{})
+		if _, ok := authn["jwt-default"]; ok || len(authn) != 1 {
+			t.Errorf("expected only the %s entry without the default issuer, got %v", name, authn)
+		}
+	}
+}
+
+func TestBuildAuthPolicy_KubernetesTokenReview(t *testing.T) {
+	card := testAgentCard("weather", "default")
+
+	authentication := func(t *testing.T, ingress *v1alpha1.IngressPolicy) map[string]interface{} {
+		t.Helper()
+		policy := testAgentPolicy("premium-policy", "default")
+		policy.Spec.Ingress = ingress
+		authPolicy, err := BuildAuthPolicy(policy, card, "agent-weather", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return authPolicy.Object["spec"].(map[string]interface{})["rules"].(map[string]interface{})["authentication"].(map[string]interface{})
+	}
+
+	t.Run("audiences default to the agent", func(t *testing.T) {
+		authn := authentication(t, &v1alpha1.IngressPolicy{
+			AllowedAgents:         []string{"orchestrator"},
+			KubernetesTokenReview: &v1alpha1.KubernetesTokenReview{},
+		})
+		if len(authn) != 1 {
+			t.Fatalf("expected only the TokenReview entry, got %v", authn)
+		}
+		entry := authn["kubernetes-tokenreview"].(map[string]interface{})
+		audiences := entry["kubernetesTokenReview"].(map[string]interface{})["audiences"].([]interface{})
+		if len(audiences) != 1 || audiences[0] != "weather" {
+			t.Errorf("expected audiences [weather], got %v", audiences)
+		}
+		sub := entry["overrides"].(map[string]interface{})["sub"].(map[string]interface{})
+		if sub["selector"] != "auth.identity.user.username" {
+			t.Errorf("expected sub to be mapped from the TokenReview username, got %v", sub)
+		}
+	})
+
+	t.Run("alongside JWT issuers", func(t *testing.T) {
+		authn := authentication(t, &v1alpha1.IngressPolicy{
+			AllowedUsers:          []string{"alice"},
+			Issuers:               []v1alpha1.JWTIssuer{{Name: "keycloak", IssuerURL: "https://keycloak.example.com"}},
+			KubernetesTokenReview: &v1alpha1.KubernetesTokenReview{Audiences: []string{"agents.example.com"}},
+		})
+		if _, ok := authn["jwt-keycloak"]; !ok {
+			t.Errorf("expected the JWT issuer entry, got %v", authn)
+		}
+		audiences := authn["kubernetes-tokenreview"].(map[string]interface{})["kubernetesTokenReview"].(map[string]interface{})["audiences"].([]interface{})
+		if len(audiences) != 1 || audiences[0] != "agents.example.com" {
+			t.Errorf("expected explicit audiences, got %v", audiences)
+		}
+	})
+}
+
+func TestBuildAuthPolicy_APIKey(t *testing.T) {
+	card := testAgentCard("weather", "default")
+	policy := testAgentPolicy("premium-policy", "default")
+	policy.Spec.Ingress = &v1alpha1.IngressPolicy{
+		AllowedAgents: []string{"orchestrator"},
+		AllowedUsers:  []string{"ci-*"},
+		APIKey:        &v1alpha1.APIKeyAuthentication{},
+	}
+
+	authPolicy, err := BuildAuthPolicy(policy, card, "agent-weather", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rules := authPolicy.Object["spec"].(map[string]interface{})["rules"].(map[string]interface{})
+	entry := rules["authentication"].(map[string]interface{})["api-key"].(map[string]interface{})
+
+	selector := entry["apiKey"].(map[string]interface{})
+	matchLabels := selector["selector"].(map[string]interface{})["matchLabels"].(map[string]interface{})
+	if len(matchLabels) != 2 || matchLabels["kagenti.com/api-key-policy"] != "premium-policy" || matchLabels["authorino.kuadrant.io/managed-by"] != "authorino" {
+		t.Errorf("expected the selector to default to the policy label, got %v", matchLabels)
+	}
+	if selector["allNamespaces"] != true {
+		t.Error("expected Secrets to be looked up outside the AuthConfig's namespace")
+	}
+	nsPatterns := rules["authorization"].(map[string]interface{})["api-key-namespace"].(map[string]interface{})["patternMatching"].(map[string]interface{})["patterns"].([]interface{})
+	if len(nsPatterns) != 1 || nsPatterns[0].(map[string]interface{})["predicate"] != `!has(auth.identity.metadata) || auth.identity.metadata.namespace == "default"` {
+		t.Errorf("expected keys to be confined to the policy namespace, got %v", nsPatterns)
+	}
+	prefix := entry["credentials"].(map[string]interface{})["authorizationHeader"].(map[string]interface{})["prefix"]
+	if prefix != "APIKEY" {
+		t.Errorf("expected the APIKEY prefix, got %v", prefix)
+	}
+	sub := entry["overrides"].(map[string]interface{})["sub"].(map[string]interface{})
+	if sub["selector"] != "auth.identity.metadata.name" {
+		t.Errorf("expected sub to be the Secret name, got %v", sub)
+	}
+
+	// Keys are admitted through allowedUsers, next to the agents.
+	patterns := rules["authorization"].(map[string]interface{})["agent-access"].(map[string]interface{})["patternMatching"].(map[string]interface{})["patterns"].([]interface{})
+	anyOf := patterns[0].(map[string]interface{})["any"].([]interface{})
+	if len(anyOf) != 2 || !strings.Contains(anyOf[1].(map[string]interface{})["predicate"].(string), `auth.identity.sub.startsWith("ci-")`) {
+		t.Errorf("expected the agent and a key prefix predicate, got %v", anyOf)
+	}
+
+	t.Run("explicit selector", func(t *testing.T) {
+		policy.Spec.Ingress.APIKey.MatchLabels = map[string]string{"tier": "premium"}
+		authPolicy, err := BuildAuthPolicy(policy, card, "agent-weather", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		authn := authPolicy.Object["spec"].(map[string]interface{})["rules"].(map[string]interface{})["authentication"].(map[string]interface{})
+		matchLabels := authn["api-key"].(map[string]interface{})["apiKey"].(map[string]interface{})["selector"].(map[string]interface{})["matchLabels"].(map[string]interface{})
+		if len(matchLabels) != 2 || matchLabels["tier"] != "premium" || matchLabels["authorino.kuadrant.io/managed-by"] != "authorino" {
+			t.Errorf("expected the policy's matchLabels, got %v", matchLabels)
+		}
+		if len(policy.Spec.Ingress.APIKey.MatchLabels) != 1 {
+			t.Errorf("expected the policy's matchLabels not to be modified, got %v", policy.Spec.Ingress.APIKey.MatchLabels)
+		}
+	})
+}
+
+func TestBuildAuthPolicy_AllowedUsers(t *testing.T) {
+	card := testAgentCard("weather", "default")
+
+	agentAccess := func(t *testing.T, ingress *v1alpha1.IngressPolicy) []interface{} {
+		t.Helper()
+		policy := testAgentPolicy("premium-policy", "default")
+		ingress.Issuers = policy.Spec.Ingress.Issuers
+		policy.Spec.Ingress = ingress
+		authPolicy, err := BuildAuthPolicy(policy, card, "agent-weather", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		authz := authPolicy.Object["spec"].(map[string]interface{})["rules"].(map[string]interface{})["authorization"].(map[string]interface{})
+		rule, ok := authz["agent-access"].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		patterns := rule["patternMatching"].(map[string]interface{})["patterns"].([]interface{})
+		return patterns[0].(map[string]interface{})["any"].([]interface{})
+	}
+
+	t.Run("mixed agents and users", func(t *testing.T) {
+		anyOf := agentAccess(t, &v1alpha1.IngressPolicy{
+			AllowedAgents: []string{"orchestrator"},
+			AllowedUsers:  []string{"alice@example.com", "ml-*"},
+			UserClaims:    []string{"email", "groups"},
+		})
+		var predicates []string
+		for _, p := range anyOf[1:] {
+			predicates = append(predicates, p.(map[string]interface{})["predicate"].(string))
+		}
+		want := []string{
+			`has(auth.identity.email) && (type(auth.identity.email) == list ? auth.identity.email.exists(v, v == "alice@example.com") : auth.identity.email == "alice@example.com")`,
+			`has(auth.identity.groups) && (type(auth.identity.groups) == list ? auth.identity.groups.exists(v, v == "alice@example.com") : auth.identity.groups == "alice@example.com")`,
+			`has(auth.identity.email) && (type(auth.identity.email) == list ? auth.identity.email.exists(v, v.startsWith("ml-")) : auth.identity.email.startsWith("ml-"))`,
+			`has(auth.identity.groups) && (type(auth.identity.groups) == list ? auth.identity.groups.exists(v, v.startsWith("ml-")) : auth.identity.groups.startsWith("ml-"))`,
+		}
+		if first := anyOf[0].(map[string]interface{}); first["value"] != "system:serviceaccount:default:orchestrator" {
+			t.Errorf("expected the agent predicate first, got %v", first)
+		}
+		if !slices.Equal(predicates, want) {
+			t.Errorf("expected user predicates\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(predicates, "\n"))
+		}
+	})
+
+	t.Run("users default to sub", func(t *testing.T) {
+		anyOf := agentAccess(t, &v1alpha1.IngressPolicy{AllowedUsers: []string{"alice"}})
+		if len(anyOf) != 1 || !strings.HasPrefix(anyOf[0].(map[string]interface{})["predicate"].(string), "has(auth.identity.sub)") {
+			t.Errorf("expected a single sub predicate, got %v", anyOf)
+		}
+	})
+
+	t.Run("wildcard admits users but keeps the agent restriction", func(t *testing.T) {
+		anyOf := agentAccess(t, &v1alpha1.IngressPolicy{
+			AllowedAgents: []string{"orchestrator", "planner"},
+			AllowedUsers:  []string{"*"},
+		})
+		if len(anyOf) != 3 {
+			t.Fatalf("expected two agent predicates and one user predicate, got %v", anyOf)
+		}
+		for i, sa := range []string{"system:serviceaccount:default:orchestrator", "system:serviceaccount:default:planner"} {
+			if anyOf[i].(map[string]interface{})["value"] != sa {
+				t.Errorf("expected agent predicate for %s, got %v", sa, anyOf[i])
+			}
+		}
+		want := `!has(auth.identity.sub) || !auth.identity.sub.startsWith("system:serviceaccount:")`
+		if got := anyOf[2].(map[string]interface{})["predicate"]; got != want {
+			t.Errorf("expected \"*\" to admit only non-ServiceAccount callers\nwant %s\ngot  %v", want, got)
+		}
+	})
+}
+
+func TestBuildAuthPolicy_ClaimPredicates(t *testing.T) {
+	card := testAgentCard("weather", "default")
+	policy := testAgentPolicy("premium-policy", "default")
+	policy.Spec.Ingress.ClaimPredicates = []v1alpha1.ClaimPredicate{
+		{Selector: "groups", Operator: "incl", Value: "ml-platform"},
+		{Selector: "tenant", Operator: "eq", Value: "default"},
+		{Selector: "scope", Operator: "matches", Value: `(^|\s)agents:invoke(\s|$)`},
+	}
+
+	authPolicy, err := BuildAuthPolicy(policy, card, "agent-weather", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	authz := authPolicy.Object["spec"].(map[string]interface{})["rules"].(map[string]interface{})["authorization"].(map[string]interface{})
+	if _, ok := authz["agent-access"]; !ok {
+		t.Error("expected claim predicates to apply in addition to the agent-access rule")
+	}
+	patterns := authz["claim-predicates"].(map[string]interface{})["patternMatching"].(map[string]interface{})["patterns"].([]interface{})
+	if len(patterns) != 3 {
+		t.Fatalf("expected 3 patterns, got %v", patterns)
+	}
+	groups := patterns[0].(map[string]interface{})
+	if groups["selector"] != "auth.identity.groups" || groups["operator"] != "incl" || groups["value"] != "ml-platform" {
+		t.Errorf("unexpected groups pattern %v", groups)
+	}
+	if scope := patterns[2].(map[string]interface{}); scope["operator"] != "matches" || scope["value"] != `(^|\s)agents:invoke(\s|$)` {
+		t.Errorf("unexpected scope pattern %v", scope)
+	}
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		policy.Spec.Ingress.ClaimPredicates = []v1alpha1.ClaimPredicate{{Selector: "scope", Operator: "matches", Value: "agents:("}}
+		if _, err := BuildAuthPolicy(policy, card, "agent-weather", nil); err == nil {
+			t.Error("expected error for an invalid regular expression")
+		}
+	})
+}
+
 func TestResolveServiceAccount(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -0,0 +1,386 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	v1alpha1 "github.com/agentoperations/agent-access-control/api/v1alpha1"
+)
+
+const (
+	// labelAgent marks a workload as an agent that should be discovered.
+	labelAgent = "kagenti.com/agent"
+
+	// labelDiscoveredFrom records the workload an AgentCard was discovered from.
+	labelDiscoveredFrom = "kagenti.com/discovered-from"
+
+	// a2aAgentCardPath is the well-known path where A2A agents publish their card.
+	a2aAgentCardPath = "/.well-known/agent.json"
+
+	// mcpEndpointPath is the path where MCP agents serve JSON-RPC over streamable HTTP.
+	mcpEndpointPath = "/mcp"
+
+	// mcpProtocolVersion is the MCP protocol revision requested during initialize.
+	mcpProtocolVersion = "2025-03-26"
+
+	// mcpSessionHeader carries the session ID assigned by an MCP server.
+	mcpSessionHeader = "Mcp-Session-Id"
+
+	// maxAgentCardBytes bounds the size of a fetched agent card or MCP response.
+	maxAgentCardBytes = 1 << 20
+)
+
+// a2aAgentCard is the subset of the A2A agent card document used to build an AgentCardSpec.
+type a2aAgentCard struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	URL         string     `json:"url,omitempty"`
+	Version     string     `json:"version,omitempty"`
+	Skills      []a2aSkill `json:"skills,omitempty"`
+}
+
+type a2aSkill struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// fetchA2AAgentCard retrieves and decodes the A2A agent card served under baseURL.
+func fetchA2AAgentCard(ctx context.Context, httpClient *http.Client, baseURL string) (*a2aAgentCard, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+a2aAgentCardPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build agent card request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch agent card: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d fetching agent card", resp.StatusCode)
+	}
+
+	var card a2aAgentCard
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxAgentCardBytes)).Decode(&card); err != nil {
+		return nil, fmt.Errorf("failed to decode agent card: %w", err)
+	}
+	return &card, nil
+}
+
+// agentCardSpecFromA2A maps an A2A agent card onto an AgentCardSpec served on the given port.
+// Skills are named by their A2A id, falling back to the display name when no id is set.
+func agentCardSpecFromA2A(card *a2aAgentCard, port int32) v1alpha1.AgentCardSpec {
+	spec := v1alpha1.AgentCardSpec{
+		Description: card.Description,
+		Skills:      []v1alpha1.AgentSkill{},
+		Protocols:   []string{"a2a"},
+		ServicePort: port,
+	}
+
+	for _, s := range card.Skills {
+		name := s.ID
+		if name == "" {
+			name = s.Name
+		}
+		if name == "" {
+			continue
+		}
+		spec.Skills = append(spec.Skills, v1alpha1.AgentSkill{
+			Name:        name,
+			Description: s.Description,
+		})
+	}
+
+	return spec
+}
+
+// mcpServerInfo is the subset of the MCP initialize result used to describe an agent.
+type mcpServerInfo struct {
+	ServerInfo struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	} `json:"serverInfo"`
+	Instructions string `json:"instructions,omitempty"`
+}
+
+type mcpTool struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type mcpToolsListResult struct {
+	Tools      []mcpTool `json:"tools"`
+	NextCursor string    `json:"nextCursor,omitempty"`
+}
+
+type jsonRPCRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      *int        `json:"id,omitempty"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+type jsonRPCResponse struct {
+	ID     *int            `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// mcpClient is a minimal MCP streamable HTTP client sufficient for capability discovery.
+type mcpClient struct {
+	httpClient *http.Client
+	endpoint   string
+	sessionID  string
+	nextID     int
+}
+
+// fetchMCPTools performs the MCP initialize handshake against baseURL and lists the
+// server's tools, following pagination cursors until the list is exhausted.
+func fetchMCPTools(ctx context.Context, httpClient *http.Client, baseURL string) (*mcpServerInfo, []mcpTool, error) {
+	c := &mcpClient{httpClient: httpClient, endpoint: baseURL + mcpEndpointPath}
+
+	var info mcpServerInfo
+	if err := c.call(ctx, "initialize", map[string]interface{}{
+		"protocolVersion": mcpProtocolVersion,
+		"capabilities":    map[string]interface{}{},
+		"clientInfo": map[string]interface{}{
+			"name":    managedByValue,
+			"version": "v1alpha1",
+		},
+	}, &info); err != nil {
+		return nil, nil, fmt.Errorf("MCP initialize failed: %w", err)
+	}
+
+	if err := c.notify(ctx, "notifications/initialized"); err != nil {
+		return nil, nil, fmt.Errorf("MCP initialized notification failed: %w", err)
+	}
+
+	var tools []mcpTool
+	cursor := ""
+	for {
+		var params interface{}
+		if cursor != "" {
+			params = map[string]interface{}{"cursor": cursor}
+		}
+		var page mcpToolsListResult
+		if err := c.call(ctx, "tools/list", params, &page); err != nil {
+			return nil, nil, fmt.Errorf("MCP tools/list failed: %w", err)
+		}
+		tools = append(tools, page.Tools...)
+		if page.NextCursor == "" || page.NextCursor == cursor {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	return &info, tools, nil
+}
+
+// call sends a JSON-RPC request and decodes its result into out.
+func (c *mcpClient) call(ctx context.Context, method string, params, out interface{}) error {
+	c.nextID++
+	id := c.nextID
+
+	resp, err := c.post(ctx, jsonRPCRequest{JSONRPC: "2.0", ID: &id, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if sid := resp.Header.Get(mcpSessionHeader); sid != "" {
+		c.sessionID = sid
+	}
+
+	rpcResp, err := readJSONRPCResponse(resp, id)
+	if err != nil {
+		return err
+	}
+	if rpcResp.Error != nil {
+		return fmt.Errorf("JSON-RPC error %d: %s", rpcResp.Error.Code, rpcResp.Error.Message)
+	}
+	if err := json.Unmarshal(rpcResp.Result, out); err != nil {
+		return fmt.Errorf("failed to decode %s result: %w", method, err)
+	}
+	return nil
+}
+
+// notify sends a JSON-RPC notification, which has no response body.
+func (c *mcpClient) notify(ctx context.Context, method string) error {
+	resp, err := c.post(ctx, jsonRPCRequest{JSONRPC: "2.0", Method: method})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxAgentCardBytes))
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (c *mcpClient) post(ctx context.Context, msg jsonRPCRequest) (*http.Response, error) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s request: %w", msg.Method, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build %s request: %w", msg.Method, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	if c.sessionID != "" {
+		req.Header.Set(mcpSessionHeader, c.sessionID)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send %s request: %w", msg.Method, err)
+	}
+	return resp, nil
+}
+
+// readJSONRPCResponse reads the response matching id from either a plain JSON body
+// or a text/event-stream body, as permitted by the MCP streamable HTTP transport.
+func readJSONRPCResponse(resp *http.Response, id int) (*jsonRPCResponse, error) {
+	body := io.LimitReader(resp.Body, maxAgentCardBytes)
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		var rpcResp jsonRPCResponse
+		if err := json.NewDecoder(body).Decode(&rpcResp); err != nil {
+			return nil, fmt.Errorf("failed to decode JSON-RPC response: %w", err)
+		}
+		return &rpcResp, nil
+	}
+
+	// Each SSE event carries one JSON-RPC message in its data lines, which are joined
+	// with newlines; server requests and notifications may precede the response we are
+	// waiting for.
+	matches := func(data []string) (*jsonRPCResponse, bool) {
+		var rpcResp jsonRPCResponse
+		if err := json.Unmarshal([]byte(strings.Join(data, "\n")), &rpcResp); err != nil || rpcResp.ID == nil || *rpcResp.ID != id {
+			return nil, false
+		}
+		return &rpcResp, true
+	}
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxAgentCardBytes)
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "data:") {
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+			continue
+		}
+		if line != "" || len(data) == 0 {
+			continue
+		}
+		if rpcResp, ok := matches(data); ok {
+			return rpcResp, nil
+		}
+		data = data[:0]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read event stream: %w", err)
+	}
+	if rpcResp, ok := matches(data); ok {
+		return rpcResp, nil
+	}
+	return nil, errors.New("event stream ended without a JSON-RPC response")
+}
+
+// agentCardSpecFromMCP maps an MCP server's tools onto an AgentCardSpec served on the given port.
+// Each tool becomes a skill, and the card advertises the mcp protocol so that an
+// MCPServerRegistration is generated for it.
+func agentCardSpecFromMCP(info *mcpServerInfo, tools []mcpTool, port int32) v1alpha1.AgentCardSpec {
+	description := info.Instructions
+	if description == "" {
+		description = info.ServerInfo.Name
+	}
+
+	spec := v1alpha1.AgentCardSpec{
+		Description: description,
+		Skills:      []v1alpha1.AgentSkill{},
+		Protocols:   []string{"mcp"},
+		ServicePort: port,
+	}
+
+	for _, t := range tools {
+		if t.Name == "" {
+			continue
+		}
+		spec.Skills = append(spec.Skills, v1alpha1.AgentSkill{
+			Name:        t.Name,
+			Description: t.Description,
+		})
+	}
+
+	return spec
+}
+
+// discoverAgentCardSpec probes an agent for its capabilities. The A2A agent card is
+// tried first; agents that do not publish one are probed as MCP servers.
+func discoverAgentCardSpec(ctx context.Context, httpClient *http.Client, baseURL string, port int32) (v1alpha1.AgentCardSpec, error) {
+	a2aCard, a2aErr := fetchA2AAgentCard(ctx, httpClient, baseURL)
+	if a2aErr == nil {
+		return agentCardSpecFromA2A(a2aCard, port), nil
+	}
+
+	info, tools, mcpErr := fetchMCPTools(ctx, httpClient, baseURL)
+	if mcpErr == nil {
+		return agentCardSpecFromMCP(info, tools, port), nil
+	}
+
+	return v1alpha1.AgentCardSpec{}, fmt.Errorf("no agent metadata found: a2a: %v; mcp: %v", a2aErr, mcpErr)
+}
+
+// serviceMatchesPods checks if a Service's selector selects pods carrying the given labels.
+// Services without a selector never match.
+func serviceMatchesPods(svc *corev1.Service, podLabels map[string]string) bool {
+	if len(svc.Spec.Selector) == 0 {
+		return false
+	}
+	return labelsMatchSelector(podLabels, svc.Spec.Selector)
+}
+
+// agentServicePort picks the port used to reach the agent behind a Service.
+// A port named "a2a", "mcp" or "http" is preferred; otherwise the first port is used.
+func agentServicePort(svc *corev1.Service) (int32, bool) {
+	if len(svc.Spec.Ports) == 0 {
+		return 0, false
+	}
+	for _, name := range []string{"a2a", "mcp", "http"} {
+		for _, p := range svc.Spec.Ports {
+			if p.Name == name {
+				return p.Port, true
+			}
+		}
+	}
+	return svc.Spec.Ports[0].Port, true
+}
+
+// serviceBaseURL returns the in-cluster base URL for a Service on the given port.
+func serviceBaseURL(svc *corev1.Service, port int32) string {
+	return fmt.Sprintf("http://%s.%s.svc:%d", svc.Name, svc.Namespace, port)
+}
@@ -0,0 +1,354 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	v1alpha1 "github.com/agentoperations/agent-access-control/api/v1alpha1"
+)
+
+const (
+	defaultDiscoveryTimeout  = 10 * time.Second
+	defaultDiscoveryInterval = 5 * time.Minute
+
+	// conditionReachable reports whether the agent's metadata endpoint answered the last discovery attempt.
+	conditionReachable = "Reachable"
+)
+
+// AgentDiscoveryReconciler discovers agents from Deployments labeled kagenti.com/agent=true
+// and creates an AgentCard for each from the agent's published metadata: the A2A
+// agent card, or the MCP tools/list result for agents that only speak MCP.
+type AgentDiscoveryReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// HTTPClient is used to fetch agent metadata. A client with a short timeout is used when nil.
+	HTTPClient *http.Client
+
+	// Interval is how often agent metadata is re-fetched to pick up new versions.
+	// Defaults to five minutes when zero.
+	Interval time.Duration
+
+	// PropagateLabels lists the workload label keys copied onto discovered AgentCards
+	// and kept in sync with the Deployment.
+	PropagateLabels []string
+}
+
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch
+// +kubebuilder:rbac:groups=kagenti.com,resources=agentcards,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=kagenti.com,resources=agentcards/status,verbs=get;update;patch
+
+// Reconcile handles discovery for a single agent Deployment.
+func (r *AgentDiscoveryReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var deploy appsv1.Deployment
+	if err := r.Get(ctx, req.NamespacedName, &deploy); err != nil {
+		if apierrors.IsNotFound(err) {
+			// The owned AgentCard is garbage-collected with the Deployment.
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("failed to fetch Deployment: %w", err)
+	}
+
+	if deploy.Labels[labelAgent] != "true" || !deploy.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, r.deleteDiscoveredCard(ctx, &deploy)
+	}
+
+	if deploy.Status.AvailableReplicas == 0 {
+		logger.Info("Agent Deployment has no available replicas, waiting", "deployment", deploy.Name)
+		return r.markUnreachable(ctx, &deploy, fmt.Errorf("no available replicas"))
+	}
+
+	svc, err := r.findServiceForDeployment(ctx, &deploy)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if svc == nil {
+		logger.Info("No Service selects agent Deployment, waiting", "deployment", deploy.Name)
+		return r.markUnreachable(ctx, &deploy, fmt.Errorf("no Service selects the agent pods"))
+	}
+
+	port, ok := agentServicePort(svc)
+	if !ok {
+		logger.Info("Agent Service exposes no ports, skipping", "service", svc.Name)
+		return r.markUnreachable(ctx, &deploy, fmt.Errorf("service %s exposes no ports", svc.Name))
+	}
+
+	spec, err := discoverAgentCardSpec(ctx, r.httpClient(), serviceBaseURL(svc, port), port)
+	if err != nil {
+		result, markErr := r.markUnreachable(ctx, &deploy, err)
+		if markErr != nil {
+			return result, markErr
+		}
+		if result.IsZero() {
+			// Nothing was discovered yet; retry with backoff.
+			return ctrl.Result{}, fmt.Errorf("failed to discover agent %s: %w", deploy.Name, err)
+		}
+		logger.Info("Agent metadata unreachable, keeping last discovered AgentCard", "deployment", deploy.Name, "error", err.Error())
+		return result, nil
+	}
+
+	desired := BuildDiscoveredAgentCard(&deploy, spec, r.PropagateLabels)
+	card, err := r.createOrUpdateAgentCard(ctx, &deploy, desired)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if card != nil {
+		if err := r.markReachable(ctx, card); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: r.interval()}, nil
+}
+
+// markReachable records a successful discovery on the AgentCard status.
+func (r *AgentDiscoveryReconciler) markReachable(ctx context.Context, card *v1alpha1.AgentCard) error {
+	now := metav1.Now()
+	card.Status.LastDiscoveryTime = &now
+	meta.SetStatusCondition(&card.Status.Conditions, metav1.Condition{
+		Type:               conditionReachable,
+		Status:             metav1.ConditionTrue,
+		Reason:             "DiscoverySucceeded",
+		Message:            "Agent metadata fetched successfully",
+		ObservedGeneration: card.Generation,
+		LastTransitionTime: now,
+	})
+
+	if err := r.Status().Update(ctx, card); err != nil {
+		return fmt.Errorf("failed to update AgentCard status: %w", err)
+	}
+	return nil
+}
+
+// markUnreachable flags the discovered AgentCard as stale when the agent can no longer
+// be reached. The spec is left as last discovered. If no discovered AgentCard exists
+// yet, an empty result is returned and the caller waits for the workload to settle.
+func (r *AgentDiscoveryReconciler) markUnreachable(ctx context.Context, deploy *appsv1.Deployment, cause error) (ctrl.Result, error) {
+	card := &v1alpha1.AgentCard{}
+	if err := r.Get(ctx, types.NamespacedName{Name: deploy.Name, Namespace: deploy.Namespace}, card); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(card, deploy) {
+		return ctrl.Result{}, nil
+	}
+
+	lastSeen := "never"
+	if card.Status.LastDiscoveryTime != nil {
+		lastSeen = card.Status.LastDiscoveryTime.UTC().Format(time.RFC3339)
+	}
+	meta.SetStatusCondition(&card.Status.Conditions, metav1.Condition{
+		Type:               conditionReachable,
+		Status:             metav1.ConditionFalse,
+		Reason:             "DiscoveryFailed",
+		Message:            fmt.Sprintf("Agent metadata is stale (last fetched: %s): %v", lastSeen, cause),
+		ObservedGeneration: card.Generation,
+		LastTransitionTime: metav1.Now(),
+	})
+
+	if err := r.Status().Update(ctx, card); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to update AgentCard status: %w", err)
+	}
+	return ctrl.Result{RequeueAfter: r.interval()}, nil
+}
+
+// interval returns the configured re-discovery interval or the default.
+func (r *AgentDiscoveryReconciler) interval() time.Duration {
+	if r.Interval > 0 {
+		return r.Interval
+	}
+	return defaultDiscoveryInterval
+}
+
+// httpClient returns the configured HTTP client or a default with a short timeout.
+func (r *AgentDiscoveryReconciler) httpClient() *http.Client {
+	if r.HTTPClient != nil {
+		return r.HTTPClient
+	}
+	return &http.Client{Timeout: defaultDiscoveryTimeout}
+}
+
+// findServiceForDeployment returns the first Service in the Deployment's namespace
+// whose selector matches the Deployment's pod template, or nil if none does.
+func (r *AgentDiscoveryReconciler) findServiceForDeployment(ctx context.Context, deploy *appsv1.Deployment) (*corev1.Service, error) {
+	var svcList corev1.ServiceList
+	if err := r.List(ctx, &svcList, client.InNamespace(deploy.Namespace)); err != nil {
+		return nil, fmt.Errorf("failed to list Services: %w", err)
+	}
+
+	for i := range svcList.Items {
+		if serviceMatchesPods(&svcList.Items[i], deploy.Spec.Template.Labels) {
+			return &svcList.Items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// createOrUpdateAgentCard creates the discovered AgentCard or updates its spec and labels,
+// returning the persisted AgentCard. An existing AgentCard that is not controlled by the
+// Deployment is left untouched so hand-written cards are never overwritten by discovery;
+// nil is returned in that case.
+func (r *AgentDiscoveryReconciler) createOrUpdateAgentCard(ctx context.Context, deploy *appsv1.Deployment, desired *v1alpha1.AgentCard) (*v1alpha1.AgentCard, error) {
+	logger := log.FromContext(ctx)
+
+	existing := &v1alpha1.AgentCard{}
+	err := r.Get(ctx, types.NamespacedName{Name: desired.Name, Namespace: desired.Namespace}, existing)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			if err := r.Create(ctx, desired); err != nil {
+				return nil, fmt.Errorf("failed to create AgentCard: %w", err)
+			}
+			logger.Info("Created discovered AgentCard", "name", desired.Name)
+			return desired, nil
+		}
+		return nil, fmt.Errorf("failed to get AgentCard: %w", err)
+	}
+
+	if !metav1.IsControlledBy(existing, deploy) {
+		logger.Info("AgentCard exists and is not managed by discovery, skipping", "name", existing.Name)
+		return nil, nil
+	}
+
+	labelsChanged := r.syncLabels(existing, desired)
+	if !labelsChanged && equality.Semantic.DeepEqual(existing.Spec, desired.Spec) {
+		return existing, nil
+	}
+
+	existing.Spec = desired.Spec
+	if err := r.Update(ctx, existing); err != nil {
+		return nil, fmt.Errorf("failed to update AgentCard: %w", err)
+	}
+	logger.Info("Updated discovered AgentCard", "name", existing.Name)
+	return existing, nil
+}
+
+// syncLabels copies the desired discovery labels onto the existing AgentCard and removes
+// propagated labels that are no longer set on the workload. Labels outside the
+// allowlist are left alone. It reports whether any label changed.
+func (r *AgentDiscoveryReconciler) syncLabels(existing, desired *v1alpha1.AgentCard) bool {
+	if existing.Labels == nil {
+		existing.Labels = map[string]string{}
+	}
+
+	changed := false
+	for _, key := range r.PropagateLabels {
+		if _, ok := desired.Labels[key]; ok {
+			continue
+		}
+		if _, ok := existing.Labels[key]; ok {
+			delete(existing.Labels, key)
+			changed = true
+		}
+	}
+	for k, v := range desired.Labels {
+		if existing.Labels[k] != v {
+			existing.Labels[k] = v
+			changed = true
+		}
+	}
+	return changed
+}
+
+// deleteDiscoveredCard removes the AgentCard discovered from a Deployment that is no
+// longer labeled as an agent.
+func (r *AgentDiscoveryReconciler) deleteDiscoveredCard(ctx context.Context, deploy *appsv1.Deployment) error {
+	existing := &v1alpha1.AgentCard{}
+	if err := r.Get(ctx, types.NamespacedName{Name: deploy.Name, Namespace: deploy.Namespace}, existing); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	if !metav1.IsControlledBy(existing, deploy) {
+		return nil
+	}
+
+	if err := r.Delete(ctx, existing); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	log.FromContext(ctx).Info("Deleted discovered AgentCard", "name", existing.Name)
+	return nil
+}
+
+// findDeploymentsForService maps a Service to the agent Deployments whose pods it selects.
+func (r *AgentDiscoveryReconciler) findDeploymentsForService(ctx context.Context, obj client.Object) []reconcile.Request {
+	logger := log.FromContext(ctx)
+
+	svc, ok := obj.(*corev1.Service)
+	if !ok {
+		return nil
+	}
+
+	var deployList appsv1.DeploymentList
+	if err := r.List(ctx, &deployList,
+		client.InNamespace(svc.Namespace),
+		client.MatchingLabels{labelAgent: "true"},
+	); err != nil {
+		logger.Error(err, "failed to list Deployments for mapping")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, deploy := range deployList.Items {
+		if serviceMatchesPods(svc, deploy.Spec.Template.Labels) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Name:      deploy.Name,
+					Namespace: deploy.Namespace,
+				},
+			})
+		}
+	}
+
+	return requests
+}
+
+// isAgent reports whether obj carries the discovery label.
+func isAgent(obj client.Object) bool {
+	return obj.GetLabels()[labelAgent] == "true"
+}
+
+// agentDeploymentPredicate admits events for Deployments carrying the discovery label.
+// Updates are also admitted when the old object carried it, so that removing the label
+// deletes the discovered AgentCard.
+var agentDeploymentPredicate = predicate.Funcs{
+	CreateFunc:  func(e event.CreateEvent) bool { return isAgent(e.Object) },
+	DeleteFunc:  func(e event.DeleteEvent) bool { return isAgent(e.Object) },
+	GenericFunc: func(e event.GenericEvent) bool { return isAgent(e.Object) },
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		return isAgent(e.ObjectOld) || isAgent(e.ObjectNew)
+	},
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *AgentDiscoveryReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("agentdiscovery").
+		For(&appsv1.Deployment{}, builder.WithPredicates(agentDeploymentPredicate)).
+		// Status updates from discovery itself must not retrigger a fetch.
+		Owns(&v1alpha1.AgentCard{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(
+			&corev1.Service{},
+			handler.EnqueueRequestsFromMapFunc(r.findDeploymentsForService),
+		).
+		Complete(r)
+}
@@ -0,0 +1,305 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	v1alpha1 "github.com/agentoperations/agent-access-control/api/v1alpha1"
+)
+
+func TestFetchA2AAgentCard(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != a2aAgentCardPath {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{
+			"name": "Weather Agent",
+			"description": "Weather forecasts",
+			"version": "1.0.0",
+			"skills": [
+				{"id": "get-forecast", "name": "Get forecast", "description": "Returns a forecast"},
+				{"name": "alerts"}
+			]
+		}`))
+	}))
+	defer srv.Close()
+
+	card, err := fetchA2AAgentCard(context.Background(), srv.Client(), srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	spec := agentCardSpecFromA2A(card, 9090)
+	if spec.Description != "Weather forecasts" {
+		t.Errorf("expected description 'Weather forecasts', got %q", spec.Description)
+	}
+	if len(spec.Protocols) != 1 || spec.Protocols[0] != "a2a" {
+		t.Errorf("expected protocols [a2a], got %v", spec.Protocols)
+	}
+	if spec.ServicePort != 9090 {
+		t.Errorf("expected servicePort 9090, got %d", spec.ServicePort)
+	}
+	if len(spec.Skills) != 2 {
+		t.Fatalf("expected 2 skills, got %d", len(spec.Skills))
+	}
+	if spec.Skills[0].Name != "get-forecast" || spec.Skills[0].Description != "Returns a forecast" {
+		t.Errorf("unexpected first skill %+v", spec.Skills[0])
+	}
+	if spec.Skills[1].Name != "alerts" {
+		t.Errorf("expected skill name to fall back to 'alerts', got %q", spec.Skills[1].Name)
+	}
+}
+
+func TestFetchA2AAgentCard_NotFound(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	if _, err := fetchA2AAgentCard(context.Background(), srv.Client(), srv.URL); err == nil {
+		t.Error("expected error for missing agent card")
+	}
+}
+
+func TestAgentServicePort(t *testing.T) {
+	svc := &corev1.Service{
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{Name: "metrics", Port: 9100},
+				{Name: "http", Port: 8080},
+			},
+		},
+	}
+	if port, ok := agentServicePort(svc); !ok || port != 8080 {
+		t.Errorf("expected named http port 8080, got %d", port)
+	}
+
+	svc.Spec.Ports = svc.Spec.Ports[:1]
+	if port, ok := agentServicePort(svc); !ok || port != 9100 {
+		t.Errorf("expected first port 9100, got %d", port)
+	}
+
+	svc.Spec.Ports = nil
+	if _, ok := agentServicePort(svc); ok {
+		t.Error("expected no port for Service without ports")
+	}
+}
+
+func TestServiceMatchesPods(t *testing.T) {
+	podLabels := map[string]string{"app": "weather", "version": "v1"}
+
+	svc := &corev1.Service{Spec: corev1.ServiceSpec{Selector: map[string]string{"app": "weather"}}}
+	if !serviceMatchesPods(svc, podLabels) {
+		t.Error("expected Service to select pods")
+	}
+
+	svc.Spec.Selector = map[string]string{"app": "code"}
+	if serviceMatchesPods(svc, podLabels) {
+		t.Error("expected Service not to select pods")
+	}
+
+	svc.Spec.Selector = nil
+	if serviceMatchesPods(svc, podLabels) {
+		t.Error("expected selectorless Service not to match")
+	}
+}
+
+func TestDiscoverAgentCardSpec_MCP(t *testing.T) {
+	var sawInitialized bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != mcpEndpointPath || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+
+		var req jsonRPCRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+			return
+		}
+
+		switch req.Method {
+		case "initialize":
+			w.Header().Set(mcpSessionHeader, "session-1")
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":{"serverInfo":{"name":"code-tools"}}}`, *req.ID)
+		case "notifications/initialized":
+			sawInitialized = true
+			w.WriteHeader(http.StatusAccepted)
+		case "tools/list":
+			if r.Header.Get(mcpSessionHeader) != "session-1" {
+				t.Errorf("expected session header on tools/list, got %q", r.Header.Get(mcpSessionHeader))
+			}
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "event: message\ndata: {\"jsonrpc\":\"2.0\",\"id\":%d,\"result\":{\"tools\":["+
+				"{\"name\":\"lint\",\"description\":\"Lint a file\"},{\"name\":\"format\"}]}}\n\n", *req.ID)
+		default:
+			t.Errorf("unexpected method %q", req.Method)
+		}
+	}))
+	defer srv.Close()
+
+	spec, err := discoverAgentCardSpec(context.Background(), srv.Client(), srv.URL, 8080)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !sawInitialized {
+		t.Error("expected notifications/initialized to be sent")
+	}
+	if len(spec.Protocols) != 1 || spec.Protocols[0] != "mcp" {
+		t.Errorf("expected protocols [mcp], got %v", spec.Protocols)
+	}
+	if spec.Description != "code-tools" {
+		t.Errorf("expected description 'code-tools', got %q", spec.Description)
+	}
+	if len(spec.Skills) != 2 || spec.Skills[0].Name != "lint" || spec.Skills[1].Name != "format" {
+		t.Errorf("expected skills [lint format], got %+v", spec.Skills)
+	}
+}
+
+func TestReadJSONRPCResponse_MultiLineEvent(t *testing.T) {
+	// A notification precedes the response, whose JSON is split across data lines.
+	stream := "event: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\"}\n\n" +
+		"event: message\ndata: {\"jsonrpc\":\"2.0\",\n" +
+		"data: \"id\":3,\n" +
+		"data: \"result\":{\"tools\":[]}}\n\n"
+	resp := &http.Response{
+		Header: http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:   io.NopCloser(strings.NewReader(stream)),
+	}
+
+	rpcResp, err := readJSONRPCResponse(resp, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(rpcResp.Result) != `{"tools":[]}` {
+		t.Errorf("expected result {\"tools\":[]}, got %s", rpcResp.Result)
+	}
+}
+
+func TestDiscoverAgentCardSpec_NoMetadata(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	if _, err := discoverAgentCardSpec(context.Background(), srv.Client(), srv.URL, 8080); err == nil {
+		t.Error("expected error when agent serves neither A2A nor MCP")
+	}
+}
+
+func TestAgentDeploymentPredicate(t *testing.T) {
+	agent := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "weather", Labels: map[string]string{labelAgent: "true"}}}
+	other := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "nginx"}}
+
+	if !agentDeploymentPredicate.Create(event.CreateEvent{Object: agent}) {
+		t.Error("expected create of a labelled Deployment to be admitted")
+	}
+	if agentDeploymentPredicate.Create(event.CreateEvent{Object: other}) {
+		t.Error("expected create of an unlabelled Deployment to be filtered")
+	}
+	if agentDeploymentPredicate.Update(event.UpdateEvent{ObjectOld: other, ObjectNew: other}) {
+		t.Error("expected update of an unlabelled Deployment to be filtered")
+	}
+	if !agentDeploymentPredicate.Update(event.UpdateEvent{ObjectOld: agent, ObjectNew: other}) {
+		t.Error("expected removal of the label to be admitted so the card is deleted")
+	}
+}
+
+func TestAgentDiscoveryReconciler_MarksStaleCard(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = v1alpha1.AddToScheme(scheme)
+
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "weather",
+			Namespace: "default",
+			UID:       types.UID("test-uid-deploy"),
+			Labels:    map[string]string{labelAgent: "true"},
+		},
+		Status: appsv1.DeploymentStatus{AvailableReplicas: 0},
+	}
+	lastSeen := metav1.NewTime(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	card := BuildDiscoveredAgentCard(deploy, testAgentCard("weather", "default").Spec, nil)
+	card.Status.LastDiscoveryTime = &lastSeen
+
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(deploy, card).
+		WithStatusSubresource(&v1alpha1.AgentCard{}).
+		Build()
+	r := &AgentDiscoveryReconciler{Client: c, Scheme: scheme, Interval: time.Minute}
+
+	result, err := r.Reconcile(context.Background(), ctrl.Request{
+		NamespacedName: types.NamespacedName{Name: "weather", Namespace: "default"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.RequeueAfter != time.Minute {
+		t.Errorf("expected requeue after 1m, got %v", result.RequeueAfter)
+	}
+
+	var got v1alpha1.AgentCard
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "weather", Namespace: "default"}, &got); err != nil {
+		t.Fatalf("failed to get AgentCard: %v", err)
+	}
+	cond := meta.FindStatusCondition(got.Status.Conditions, conditionReachable)
+	if cond == nil || cond.Status != metav1.ConditionFalse {
+		t.Fatalf("expected Reachable=False condition, got %+v", cond)
+	}
+	if !strings.Contains(cond.Message, "2026-01-02T03:04:05Z") {
+		t.Errorf("expected last fetch time in message, got %q", cond.Message)
+	}
+	if len(got.Spec.Skills) != 1 {
+		t.Errorf("expected last discovered spec to be kept, got %+v", got.Spec)
+	}
+}
+
+func TestAgentDiscoveryReconciler_SyncLabels(t *testing.T) {
+	r := &AgentDiscoveryReconciler{PropagateLabels: []string{"tier", "team"}}
+
+	existing := &v1alpha1.AgentCard{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+		"tier":   "standard",
+		"team":   "forecasting",
+		"custom": "kept",
+	}}}
+	desired := &v1alpha1.AgentCard{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+		labelManagedBy: managedByValue,
+		"tier":         "premium",
+	}}}
+
+	if !r.syncLabels(existing, desired) {
+		t.Fatal("expected labels to change")
+	}
+	if existing.Labels["tier"] != "premium" {
+		t.Errorf("expected tier=premium, got %q", existing.Labels["tier"])
+	}
+	if _, ok := existing.Labels["team"]; ok {
+		t.Error("expected team label removed from workload to be removed from card")
+	}
+	if existing.Labels["custom"] != "kept" {
+		t.Error("expected labels outside the allowlist to be kept")
+	}
+	if r.syncLabels(existing, desired) {
+		t.Error("expected second sync to be a no-op")
+	}
+}
@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	v1alpha1 "github.com/agentoperations/agent-access-control/api/v1alpha1"
+)
+
+const (
+	// PodLabelerPath is the path the pod-labeling webhook is served on.
+	PodLabelerPath = "/mutate-v1-pod-agent-card"
+)
+
+// PodLabeler is a mutating admission webhook that stamps the kagenti.com/agent-card
+// label onto pods of workloads backing an AgentCard, so the generated egress
+// NetworkPolicy selects them.
+type PodLabeler struct {
+	Client  client.Reader
+	Decoder admission.Decoder
+}
+
+// +kubebuilder:webhook:path=/mutate-v1-pod-agent-card,mutating=true,failurePolicy=ignore,sideEffects=None,groups="",resources=pods,verbs=create,versions=v1,name=agent-card.pods.kagenti.com,admissionReviewVersions=v1
+// +kubebuilder:rbac:groups=apps,resources=replicasets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+
+// Handle labels the pod with the AgentCard it backs. Pods that back no AgentCard are admitted unchanged.
+func (w *PodLabeler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	logger := log.FromContext(ctx)
+
+	pod := &corev1.Pod{}
+	if err := w.Decoder.Decode(req, pod); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	if pod.Labels[labelAgentCard] != "" {
+		return admission.Allowed("pod already labeled")
+	}
+
+	card, err := agentCardForPod(ctx, w.Client, req.Namespace, pod)
+	if err != nil {
+		logger.Error(err, "failed to resolve AgentCard for pod")
+		return admission.Allowed("unable to resolve AgentCard")
+	}
+	if card == nil {
+		return admission.Allowed("pod does not back an AgentCard")
+	}
+
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
+	}
+	pod.Labels[labelAgentCard] = card.Name
+
+	marshaled, err := json.Marshal(pod)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// agentCardForPod finds the AgentCard backed by a pod. A discovered AgentCard matches
+// when the pod belongs to the Deployment it was discovered from; any AgentCard matches
+// when its {card}-svc Service selects the pod. It returns nil if no AgentCard matches.
+func agentCardForPod(ctx context.Context, c client.Reader, namespace string, pod *corev1.Pod) (*v1alpha1.AgentCard, error) {
+	var cardList v1alpha1.AgentCardList
+	if err := c.List(ctx, &cardList, client.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("failed to list AgentCards: %w", err)
+	}
+	if len(cardList.Items) == 0 {
+		return nil, nil
+	}
+
+	deployName, err := owningDeploymentName(ctx, c, namespace, pod)
+	if err != nil {
+		return nil, err
+	}
+	if deployName != "" {
+		for i := range cardList.Items {
+			if cardList.Items[i].Labels[labelDiscoveredFrom] == deployName {
+				return &cardList.Items[i], nil
+			}
+		}
+	}
+
+	for i := range cardList.Items {
+		card := &cardList.Items[i]
+		svc := &corev1.Service{}
+		if err := c.Get(ctx, types.NamespacedName{Name: card.Name + "-svc", Namespace: namespace}, svc); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to get Service for AgentCard %s: %w", card.Name, err)
+		}
+		if serviceMatchesPods(svc, pod.Labels) {
+			return card, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// owningDeploymentName follows the pod's controlling ReplicaSet to its Deployment.
+// It returns an empty name for pods not managed by a Deployment.
+func owningDeploymentName(ctx context.Context, c client.Reader, namespace string, pod *corev1.Pod) (string, error) {
+	rsRef := metav1.GetControllerOf(pod)
+	if rsRef == nil || rsRef.Kind != "ReplicaSet" {
+		return "", nil
+	}
+
+	rs := &appsv1.ReplicaSet{}
+	if err := c.Get(ctx, types.NamespacedName{Name: rsRef.Name, Namespace: namespace}, rs); err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get ReplicaSet %s: %w", rsRef.Name, err)
+	}
+
+	deployRef := metav1.GetControllerOf(rs)
+	if deployRef == nil || deployRef.Kind != "Deployment" {
+		return "", nil
+	}
+	return deployRef.Name, nil
+}
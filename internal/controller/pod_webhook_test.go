@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	v1alpha1 "github.com/agentoperations/agent-access-control/api/v1alpha1"
+)
+
+func testWebhookScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = v1alpha1.AddToScheme(scheme)
+	return scheme
+}
+
+func podAdmissionRequest(t *testing.T, pod *corev1.Pod) admission.Request {
+	t.Helper()
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatalf("failed to marshal pod: %v", err)
+	}
+	return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		Namespace: "default",
+		Object:    runtime.RawExtension{Raw: raw},
+	}}
+}
+
+func hasAgentCardLabelPatch(resp admission.Response, cardName string) bool {
+	for _, p := range resp.Patches {
+		if p.Path == "/metadata/labels" {
+			if labels, ok := p.Value.(map[string]interface{}); ok && labels[labelAgentCard] == cardName {
+				return true
+			}
+		}
+		if p.Path == "/metadata/labels/kagenti.com~1agent-card" && p.Value == cardName {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPodLabeler_DiscoveredDeployment(t *testing.T) {
+	scheme := testWebhookScheme()
+	isController := true
+
+	deploy := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "weather", Namespace: "default", UID: "deploy-uid"}}
+	rs := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Name:      "weather-5d9f",
+		Namespace: "default",
+		OwnerReferences: []metav1.OwnerReference{
+			{APIVersion: "apps/v1", Kind: "Deployment", Name: "weather", UID: "deploy-uid", Controller: &isController},
+		},
+	}}
+	card := BuildDiscoveredAgentCard(deploy, testAgentCard("weather", "default").Spec, nil)
+
+	labeler := &PodLabeler{
+		Client:  fake.NewClientBuilder().WithScheme(scheme).WithObjects(rs, card).Build(),
+		Decoder: admission.NewDecoder(scheme),
+	}
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		GenerateName: "weather-5d9f-",
+		Labels:       map[string]string{"app": "weather"},
+		OwnerReferences: []metav1.OwnerReference{
+			{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "weather-5d9f", UID: "rs-uid", Controller: &isController},
+		},
+	}}
+
+	resp := labeler.Handle(context.Background(), podAdmissionRequest(t, pod))
+	if !resp.Allowed {
+		t.Fatalf("expected pod to be admitted, got %+v", resp.Result)
+	}
+	if !hasAgentCardLabelPatch(resp, "weather") {
+		t.Errorf("expected patch adding %s=weather, got %+v", labelAgentCard, resp.Patches)
+	}
+}
+
+func TestPodLabeler_ServiceSelector(t *testing.T) {
+	scheme := testWebhookScheme()
+
+	card := testAgentCard("code-review", "default")
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "code-review-svc", Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "code-review"}},
+	}
+
+	labeler := &PodLabeler{
+		Client:  fake.NewClientBuilder().WithScheme(scheme).WithObjects([]client.Object{card, svc}...).Build(),
+		Decoder: admission.NewDecoder(scheme),
+	}
+
+	t.Run("selected_pod", func(t *testing.T) {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "code-review"}}}
+		resp := labeler.Handle(context.Background(), podAdmissionRequest(t, pod))
+		if !resp.Allowed || !hasAgentCardLabelPatch(resp, "code-review") {
+			t.Errorf("expected patch adding %s=code-review, got %+v", labelAgentCard, resp.Patches)
+		}
+	})
+
+	t.Run("unrelated_pod", func(t *testing.T) {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "other"}}}
+		resp := labeler.Handle(context.Background(), podAdmissionRequest(t, pod))
+		if !resp.Allowed || len(resp.Patches) != 0 {
+			t.Errorf("expected unrelated pod to be admitted unchanged, got %+v", resp.Patches)
+		}
+	})
+}
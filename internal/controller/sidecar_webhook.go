@@ -0,0 +1,349 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	v1alpha1 "github.com/agentoperations/agent-access-control/api/v1alpha1"
+	"github.com/agentoperations/agent-access-control/pkg/sidecarconfig"
+)
+
+const (
+	// SidecarInjectorPath is the path the sidecar injection webhook is served on.
+	SidecarInjectorPath = "/mutate-v1-pod-sidecar"
+
+	// labelInjectSidecar opts a pod into auth sidecar injection when set to "true". It is
+	// a label so that the webhook configuration's objectSelector sends only opted-in pods
+	// to the injector, which then fails closed.
+	labelInjectSidecar = "kagenti.com/inject-sidecar"
+
+	// sidecarContainerName is the name of the injected auth sidecar container.
+	sidecarContainerName = "agent-sidecar"
+
+	// sidecarConfigVolume is the name of the volume holding the sidecar ConfigMap.
+	sidecarConfigVolume = "agent-sidecar-config"
+
+	// sidecarConfigDir is where the sidecar ConfigMap is mounted in the sidecar container.
+	sidecarConfigDir = "/etc/agent-sidecar"
+
+	// sidecarConfigKey is the ConfigMap key holding the sidecar configuration.
+	sidecarConfigKey = "config.yaml"
+
+	// annotationSidecarSecrets lists, comma-separated, the Secrets the sidecar config
+	// references. The injector mounts each under sidecarSecretsDir.
+	annotationSidecarSecrets = "kagenti.com/sidecar-secrets"
+
+	// sidecarSecretsDir is where referenced Secrets are mounted, one directory per Secret.
+	sidecarSecretsDir = "/var/run/agent-sidecar/secrets"
+
+	// sidecarProxyPort is the loopback port the sidecar forward proxy listens on.
+	sidecarProxyPort = 15001
+
+	// sidecarInboundPort is the port the sidecar inbound reverse proxy listens on when
+	// the sidecar config enables inbound validation. Services route to it by the name
+	// sidecarInboundPortName.
+	sidecarInboundPort     = 15002
+	sidecarInboundPortName = "agent-inbound"
+
+	// sidecarTrustContainerName is the init container that writes the trust bundle for
+	// TLS interception.
+	sidecarTrustContainerName = "agent-sidecar-trust"
+
+	// sidecarTrustVolume is the emptyDir the trust bundle is written to and shared with
+	// the application containers.
+	sidecarTrustVolume = "agent-sidecar-trust"
+
+	// sidecarTrustDir is where the trust bundle volume is mounted in every container.
+	sidecarTrustDir = "/var/run/agent-sidecar/trust"
+
+	// sidecarTrustBundle is the system CA bundle plus the interception CA.
+	sidecarTrustBundle = sidecarTrustDir + "/ca-bundle.crt"
+
+	// clusterNoProxy are the destinations application containers reach directly rather
+	// than through the sidecar: loopback and in-cluster Service names.
+	clusterNoProxy = "localhost,127.0.0.1,.svc,.cluster.local"
+)
+
+// SidecarInjector is a mutating admission webhook that injects the auth sidecar into
+// pods labelled kagenti.com/inject-sidecar=true. The sidecar mounts the
+// sidecar-config-{card} ConfigMap and the application containers are pointed at it
+// through the standard proxy environment variables.
+type SidecarInjector struct {
+	Client  client.Reader
+	Decoder admission.Decoder
+
+	// Image is the auth sidecar container image.
+	Image string
+
+	// APIServerHost is the address of the kubernetes Service (KUBERNETES_SERVICE_HOST),
+	// which is added to NO_PROXY so that clients using in-cluster config bypass the
+	// sidecar. It is the same for every pod in the cluster.
+	APIServerHost string
+}
+
+// +kubebuilder:webhook:path=/mutate-v1-pod-sidecar,mutating=true,failurePolicy=fail,sideEffects=None,groups="",resources=pods,verbs=create,versions=v1,name=sidecar.pods.kagenti.com,admissionReviewVersions=v1
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+// +kubebuilder:rbac:groups=kagenti.com,resources=agentpolicies,verbs=get;list;watch
+
+// Handle injects the auth sidecar into opted-in pods. A pod whose sidecar ConfigMap does
+// not exist yet is refused when a matching AgentPolicy denies egress by default, and
+// admitted without a sidecar otherwise.
+func (w *SidecarInjector) Handle(ctx context.Context, req admission.Request) admission.Response {
+	logger := log.FromContext(ctx)
+
+	pod := &corev1.Pod{}
+	if err := w.Decoder.Decode(req, pod); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	if pod.Labels[labelInjectSidecar] != "true" {
+		return admission.Allowed("sidecar injection not requested")
+	}
+	if hasContainer(pod.Spec.Containers, sidecarContainerName) {
+		return admission.Allowed("sidecar already injected")
+	}
+
+	card, err := w.agentCardFor(ctx, req.Namespace, pod)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if card == nil {
+		return admission.Allowed("pod does not back an AgentCard").
+			WithWarnings("kagenti.com/inject-sidecar is set but no AgentCard matches this pod; sidecar not injected")
+	}
+
+	cmName := sidecarConfigMapName(card.Name)
+	cm := &corev1.ConfigMap{}
+	if err := w.Client.Get(ctx, types.NamespacedName{Name: cmName, Namespace: req.Namespace}, cm); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return admission.Errored(http.StatusInternalServerError, fmt.Errorf("failed to get ConfigMap %s: %w", cmName, err))
+		}
+
+		deny, err := w.denyByDefault(ctx, card)
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		if deny {
+			return admission.Denied(fmt.Sprintf(
+				"sidecar ConfigMap %s not found and AgentPolicy for AgentCard %s denies egress by default", cmName, card.Name))
+		}
+		logger.Info("Sidecar ConfigMap not found, admitting pod without sidecar", "configMap", cmName)
+		return admission.Allowed("sidecar ConfigMap not found").
+			WithWarnings(fmt.Sprintf("sidecar ConfigMap %s not found; sidecar not injected", cmName))
+	}
+
+	injectSidecar(pod, w.Image, cmName, sidecarSecretNames(cm), sidecarConfigFor(cm), w.noProxy())
+
+	marshaled, err := json.Marshal(pod)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// agentCardFor returns the AgentCard named by the pod's agent-card label, or resolves
+// it from the pod's owners when the label has not been stamped yet.
+func (w *SidecarInjector) agentCardFor(ctx context.Context, namespace string, pod *corev1.Pod) (*v1alpha1.AgentCard, error) {
+	name := pod.Labels[labelAgentCard]
+	if name == "" {
+		return agentCardForPod(ctx, w.Client, namespace, pod)
+	}
+
+	card := &v1alpha1.AgentCard{}
+	if err := w.Client.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, card); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get AgentCard %s: %w", name, err)
+	}
+	return card, nil
+}
+
+// denyByDefault reports whether any AgentPolicy selecting the card has an external
+// policy whose defaultMode is deny.
+func (w *SidecarInjector) denyByDefault(ctx context.Context, card *v1alpha1.AgentCard) (bool, error) {
+	var policyList v1alpha1.AgentPolicyList
+	if err := w.Client.List(ctx, &policyList, client.InNamespace(card.Namespace)); err != nil {
+		return false, fmt.Errorf("failed to list AgentPolicies: %w", err)
+	}
+
+	for _, policy := range policyList.Items {
+		if policy.Spec.External == nil || policy.Spec.External.DefaultMode != "deny" {
+			continue
+		}
+		if labelsMatchSelector(card.Labels, policy.Spec.AgentSelector.MatchLabels) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// noProxy returns the NO_PROXY value set on application containers.
+func (w *SidecarInjector) noProxy() string {
+	if w.APIServerHost == "" {
+		return clusterNoProxy
+	}
+	return clusterNoProxy + "," + w.APIServerHost
+}
+
+// injectSidecar adds the auth sidecar container, its config volume and the Secrets the
+// config references to the pod, and routes outbound traffic of the existing containers
+// through it. cfg is the parsed sidecar config, or nil if it could not be read. When it
+// has an inbound block the sidecar also serves the inbound reverse proxy on
+// sidecarInboundPort; when it enables TLS interception an init container writes a trust
+// bundle including the interception CA, and the application containers are pointed at it.
+func injectSidecar(pod *corev1.Pod, image, configMapName string, secretNames []string, cfg *sidecarconfig.Config, noProxy string) {
+	proxyURL := fmt.Sprintf("http://127.0.0.1:%d", sidecarProxyPort)
+	proxyEnv := []corev1.EnvVar{
+		{Name: "HTTP_PROXY", Value: proxyURL},
+		{Name: "HTTPS_PROXY", Value: proxyURL},
+		{Name: "http_proxy", Value: proxyURL},
+		{Name: "https_proxy", Value: proxyURL},
+		{Name: "NO_PROXY", Value: noProxy},
+		{Name: "no_proxy", Value: noProxy},
+	}
+	for i := range pod.Spec.Containers {
+		pod.Spec.Containers[i].Env = mergeEnv(pod.Spec.Containers[i].Env, proxyEnv)
+	}
+
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: sidecarConfigVolume,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: configMapName},
+			},
+		},
+	})
+
+	mounts := []corev1.VolumeMount{
+		{Name: sidecarConfigVolume, MountPath: sidecarConfigDir, ReadOnly: true},
+	}
+	for i, name := range secretNames {
+		volume := fmt.Sprintf("%s-secret-%d", sidecarContainerName, i)
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: volume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: name},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{Name: volume, MountPath: path.Join(sidecarSecretsDir, name), ReadOnly: true})
+	}
+
+	args := []string{
+		"--config=" + sidecarConfigDir + "/" + sidecarConfigKey,
+		fmt.Sprintf("--listen=127.0.0.1:%d", sidecarProxyPort),
+	}
+	var ports []corev1.ContainerPort
+	if cfg != nil && cfg.Inbound != nil {
+		args = append(args, fmt.Sprintf("--inbound-listen=:%d", sidecarInboundPort))
+		ports = append(ports, corev1.ContainerPort{
+			Name:          sidecarInboundPortName,
+			ContainerPort: sidecarInboundPort,
+			Protocol:      corev1.ProtocolTCP,
+		})
+	}
+
+	allowPrivilegeEscalation := false
+	runAsNonRoot := true
+	securityContext := &corev1.SecurityContext{
+		AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+		RunAsNonRoot:             &runAsNonRoot,
+		Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+	}
+
+	if cfg != nil && cfg.TLSInterception != nil {
+		trustEnv := []corev1.EnvVar{
+			{Name: "SSL_CERT_FILE", Value: sidecarTrustBundle},
+			{Name: "REQUESTS_CA_BUNDLE", Value: sidecarTrustBundle},
+			{Name: "CURL_CA_BUNDLE", Value: sidecarTrustBundle},
+			{Name: "NODE_EXTRA_CA_CERTS", Value: sidecarTrustBundle},
+		}
+		trustMount := corev1.VolumeMount{Name: sidecarTrustVolume, MountPath: sidecarTrustDir, ReadOnly: true}
+		for i := range pod.Spec.Containers {
+			pod.Spec.Containers[i].Env = mergeEnv(pod.Spec.Containers[i].Env, trustEnv)
+			pod.Spec.Containers[i].VolumeMounts = append(pod.Spec.Containers[i].VolumeMounts, trustMount)
+		}
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name:         sidecarTrustVolume,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+		pod.Spec.InitContainers = append(pod.Spec.InitContainers, corev1.Container{
+			Name:  sidecarTrustContainerName,
+			Image: image,
+			Args: []string{
+				"--config=" + sidecarConfigDir + "/" + sidecarConfigKey,
+				"--write-trust-bundle=" + sidecarTrustBundle,
+			},
+			VolumeMounts:    append(slices.Clone(mounts), corev1.VolumeMount{Name: sidecarTrustVolume, MountPath: sidecarTrustDir}),
+			SecurityContext: securityContext,
+		})
+	}
+
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+		Name:            sidecarContainerName,
+		Image:           image,
+		Args:            args,
+		Ports:           ports,
+		VolumeMounts:    mounts,
+		SecurityContext: securityContext,
+	})
+}
+
+// sidecarSecretNames returns the Secrets listed in the ConfigMap's sidecar-secrets annotation.
+func sidecarSecretNames(cm *corev1.ConfigMap) []string {
+	var names []string
+	for _, name := range strings.Split(cm.Annotations[annotationSidecarSecrets], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// sidecarConfigFor parses the sidecar config in the ConfigMap, returning nil if it
+// cannot be parsed. The sidecar itself reports such configs; the injector only needs
+// to know which optional listeners and files to set up.
+func sidecarConfigFor(cm *corev1.ConfigMap) *sidecarconfig.Config {
+	cfg, err := sidecarconfig.Parse([]byte(cm.Data[sidecarConfigKey]))
+	if err != nil {
+		return nil
+	}
+	return cfg
+}
+
+// mergeEnv appends env vars that are not already set, so explicit values in the
+// pod spec take precedence over the injected ones.
+func mergeEnv(existing, add []corev1.EnvVar) []corev1.EnvVar {
+	set := make(map[string]bool, len(existing))
+	for _, e := range existing {
+		set[e.Name] = true
+	}
+	for _, e := range add {
+		if !set[e.Name] {
+			existing = append(existing, e)
+		}
+	}
+	return existing
+}
+
+// hasContainer checks if a container with the given name is present.
+func hasContainer(containers []corev1.Container, name string) bool {
+	for _, c := range containers {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,213 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	v1alpha1 "github.com/agentoperations/agent-access-control/api/v1alpha1"
+)
+
+func testInjectablePod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{labelAgentCard: "weather", labelInjectSidecar: "true"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name:  "agent",
+					Image: "weather:latest",
+					Env:   []corev1.EnvVar{{Name: "NO_PROXY", Value: "internal.example.com"}},
+				},
+			},
+		},
+	}
+}
+
+// applyPatches returns the pod as admitted, with the webhook's JSON patches applied.
+func applyPatches(t *testing.T, pod *corev1.Pod, resp admission.Response) *corev1.Pod {
+	t.Helper()
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatalf("failed to marshal pod: %v", err)
+	}
+	ops, err := json.Marshal(resp.Patches)
+	if err != nil {
+		t.Fatalf("failed to marshal patches: %v", err)
+	}
+	patch, err := jsonpatch.DecodePatch(ops)
+	if err != nil {
+		t.Fatalf("failed to decode patches: %v", err)
+	}
+	patched, err := patch.Apply(raw)
+	if err != nil {
+		t.Fatalf("failed to apply patches: %v", err)
+	}
+	admitted := &corev1.Pod{}
+	if err := json.Unmarshal(patched, admitted); err != nil {
+		t.Fatalf("failed to unmarshal patched pod: %v", err)
+	}
+	return admitted
+}
+
+func TestSidecarInjector(t *testing.T) {
+	scheme := testWebhookScheme()
+
+	card := testAgentCard("weather", "default")
+	card.Labels = map[string]string{"tier": "premium"}
+	policy := testAgentPolicy("premium-policy", "default")
+	cm, err := BuildSidecarConfigMap(policy, card)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	newInjector := func(objs ...client.Object) *SidecarInjector {
+		return &SidecarInjector{
+			Client:        fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+			Decoder:       admission.NewDecoder(scheme),
+			Image:         "sidecar:test",
+			APIServerHost: "10.96.0.1",
+		}
+	}
+
+	t.Run("injects_sidecar", func(t *testing.T) {
+		pod := testInjectablePod()
+		resp := newInjector(card, policy, cm).Handle(context.Background(), podAdmissionRequest(t, pod))
+		if !resp.Allowed {
+			t.Fatalf("expected pod to be admitted, got %+v", resp.Result)
+		}
+		if len(resp.Patches) == 0 {
+			t.Fatal("expected sidecar injection patches")
+		}
+
+		pod = applyPatches(t, pod, resp)
+		if len(pod.Spec.Containers) != 2 || pod.Spec.Containers[1].Name != sidecarContainerName {
+			t.Fatalf("expected sidecar container to be appended, got %+v", pod.Spec.Containers)
+		}
+		if len(pod.Spec.Containers[1].Ports) != 0 {
+			t.Errorf("expected no inbound port without sidecarValidation, got %+v", pod.Spec.Containers[1].Ports)
+		}
+		if pod.Spec.Volumes[0].ConfigMap == nil || pod.Spec.Volumes[0].ConfigMap.Name != "sidecar-config-weather" {
+			t.Errorf("expected volume from ConfigMap sidecar-config-weather, got %+v", pod.Spec.Volumes[0])
+		}
+
+		env := map[string]string{}
+		for _, e := range pod.Spec.Containers[0].Env {
+			env[e.Name] = e.Value
+		}
+		if env["HTTPS_PROXY"] != "http://127.0.0.1:15001" {
+			t.Errorf("expected HTTPS_PROXY to point at the sidecar, got %q", env["HTTPS_PROXY"])
+		}
+		if env["NO_PROXY"] != "internal.example.com" {
+			t.Errorf("expected explicit NO_PROXY to be preserved, got %q", env["NO_PROXY"])
+		}
+		if want := "localhost,127.0.0.1,.svc,.cluster.local,10.96.0.1"; env["no_proxy"] != want {
+			t.Errorf("expected in-cluster traffic and the API server to bypass the sidecar, got no_proxy %q, want %q", env["no_proxy"], want)
+		}
+	})
+
+	t.Run("mounts_referenced_secrets", func(t *testing.T) {
+		pod := testInjectablePod()
+		secretCM := cm.DeepCopy()
+		secretCM.Annotations = map[string]string{annotationSidecarSecrets: "idp-client, vault-ca"}
+
+		injectSidecar(pod, "sidecar:test", secretCM.Name, sidecarSecretNames(secretCM), nil, clusterNoProxy)
+		if len(pod.Spec.Volumes) != 3 || pod.Spec.Volumes[2].Secret == nil || pod.Spec.Volumes[2].Secret.SecretName != "vault-ca" {
+			t.Fatalf("expected Secret volumes for idp-client and vault-ca, got %+v", pod.Spec.Volumes)
+		}
+		mounts := pod.Spec.Containers[1].VolumeMounts
+		if len(mounts) != 3 || mounts[1].MountPath != "/var/run/agent-sidecar/secrets/idp-client" || !mounts[1].ReadOnly {
+			t.Errorf("unexpected sidecar mounts %+v", mounts)
+		}
+		if len(pod.Spec.Containers[0].VolumeMounts) != 0 {
+			t.Error("expected Secrets to be mounted only into the sidecar")
+		}
+	})
+
+	t.Run("exposes_inbound_port", func(t *testing.T) {
+		inboundPolicy := testAgentPolicy("premium-policy", "default")
+		inboundPolicy.Spec.Ingress.SidecarValidation = &v1alpha1.SidecarValidation{IssuerURL: "https://issuer.example.com"}
+		inboundCM, err := BuildSidecarConfigMap(inboundPolicy, card)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		pod := testInjectablePod()
+		resp := newInjector(card, inboundPolicy, inboundCM).Handle(context.Background(), podAdmissionRequest(t, pod))
+		if !resp.Allowed {
+			t.Fatalf("expected pod to be admitted, got %+v", resp.Result)
+		}
+		sidecar := applyPatches(t, pod, resp).Spec.Containers[1]
+		if len(sidecar.Ports) != 1 || sidecar.Ports[0].Name != "agent-inbound" || sidecar.Ports[0].ContainerPort != 15002 {
+			t.Errorf("expected the agent-inbound port on the sidecar, got %+v", sidecar.Ports)
+		}
+		if sidecar.Args[len(sidecar.Args)-1] != "--inbound-listen=:15002" {
+			t.Errorf("expected --inbound-listen, got %v", sidecar.Args)
+		}
+	})
+
+	t.Run("installs_trust_bundle", func(t *testing.T) {
+		interceptPolicy := testAgentPolicy("premium-policy", "default")
+		interceptPolicy.Spec.External.TLSInterception = &v1alpha1.TLSInterceptionSpec{CASecret: "egress-ca"}
+		interceptCM, err := BuildSidecarConfigMap(interceptPolicy, card)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		pod := testInjectablePod()
+		injectSidecar(pod, "sidecar:test", interceptCM.Name, []string{"egress-ca"}, sidecarConfigFor(interceptCM), clusterNoProxy)
+		if len(pod.Spec.InitContainers) != 1 || pod.Spec.InitContainers[0].Name != "agent-sidecar-trust" {
+			t.Fatalf("expected the trust bundle init container, got %+v", pod.Spec.InitContainers)
+		}
+		if args := pod.Spec.InitContainers[0].Args; args[len(args)-1] != "--write-trust-bundle=/var/run/agent-sidecar/trust/ca-bundle.crt" {
+			t.Errorf("expected --write-trust-bundle, got %v", args)
+		}
+
+		app := pod.Spec.Containers[0]
+		var certFile string
+		for _, env := range app.Env {
+			if env.Name == "SSL_CERT_FILE" {
+				certFile = env.Value
+			}
+		}
+		if certFile != "/var/run/agent-sidecar/trust/ca-bundle.crt" {
+			t.Errorf("expected SSL_CERT_FILE to point at the trust bundle, got %q", certFile)
+		}
+		if len(app.VolumeMounts) != 1 || app.VolumeMounts[0].Name != "agent-sidecar-trust" || !app.VolumeMounts[0].ReadOnly {
+			t.Errorf("expected only the trust bundle mounted read-only into the app, got %+v", app.VolumeMounts)
+		}
+	})
+
+	t.Run("denies_without_configmap", func(t *testing.T) {
+		resp := newInjector(card, policy).Handle(context.Background(), podAdmissionRequest(t, testInjectablePod()))
+		if resp.Allowed {
+			t.Error("expected pod to be refused when ConfigMap is missing and defaultMode is deny")
+		}
+	})
+
+	t.Run("allows_without_configmap", func(t *testing.T) {
+		passthrough := testAgentPolicy("premium-policy", "default")
+		passthrough.Spec.External.DefaultMode = "passthrough"
+		resp := newInjector(card, passthrough).Handle(context.Background(), podAdmissionRequest(t, testInjectablePod()))
+		if !resp.Allowed || len(resp.Patches) != 0 {
+			t.Errorf("expected pod to be admitted unchanged, got allowed=%v patches=%+v", resp.Allowed, resp.Patches)
+		}
+	})
+
+	t.Run("not_labelled", func(t *testing.T) {
+		pod := testInjectablePod()
+		delete(pod.Labels, labelInjectSidecar)
+		resp := newInjector(card, policy, cm).Handle(context.Background(), podAdmissionRequest(t, pod))
+		if !resp.Allowed || len(resp.Patches) != 0 {
+			t.Errorf("expected pod to be admitted unchanged, got %+v", resp.Patches)
+		}
+	})
+}
@@ -0,0 +1,221 @@
+package sidecar
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Audit decisions.
+const (
+	AuditAllowed     = "allowed"
+	AuditDenied      = "denied"
+	AuditRateLimited = "rate_limited"
+)
+
+// Tunnel phases. A CONNECT tunnel is recorded once when it is established and again
+// when it closes, so that long-lived tunnels show up in the audit trail while open.
+const (
+	TunnelOpened = "opened"
+	TunnelClosed = "closed"
+)
+
+// AuditEvent records one outbound request, or one phase of a CONNECT tunnel. It never
+// carries header values, query strings or bodies, so injected credentials cannot end up
+// in the audit trail.
+type AuditEvent struct {
+	Time      time.Time `json:"time"`
+	Agent     string    `json:"agent,omitempty"`
+	Method    string    `json:"method"`
+	Host      string    `json:"host"`
+	Port      int       `json:"port,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Rule      string    `json:"rule,omitempty"`
+	Mode      string    `json:"mode"`
+	Decision  string    `json:"decision"`
+	Reason    string    `json:"reason,omitempty"`
+	Tunnel    string    `json:"tunnel,omitempty"`
+	Status    int       `json:"status"`
+	LatencyMS float64   `json:"latencyMs"`
+}
+
+// AuditLog writes one line per outbound request to each configured sink.
+type AuditLog struct {
+	mu   sync.Mutex
+	json io.Writer
+	otlp io.Writer
+}
+
+// NewAuditLog returns an audit log writing plain JSON lines to jsonOut and OTLP/JSON
+// log records, as read by the OpenTelemetry Collector's otlpjsonfile receiver, to
+// otlpOut. Either writer may be nil.
+func NewAuditLog(jsonOut, otlpOut io.Writer) *AuditLog {
+	return &AuditLog{json: jsonOut, otlp: otlpOut}
+}
+
+// Record writes e to every sink. Write errors are dropped so that a full disk never
+// blocks egress.
+func (a *AuditLog) Record(e AuditEvent) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.json != nil {
+		if line, err := json.Marshal(e); err == nil {
+			_, _ = a.json.Write(append(line, '\n'))
+		}
+	}
+	if a.otlp != nil {
+		if line, err := json.Marshal(otlpLogsFor(e)); err == nil {
+			_, _ = a.otlp.Write(append(line, '\n'))
+		}
+	}
+}
+
+// statusRecorder captures the status code written for a request. A hijacked CONNECT
+// tunnel is recorded as 200 once established.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err == nil {
+		s.status = http.StatusOK
+	}
+	return conn, buf, err
+}
+
+// The types below are the subset of the OTLP/JSON logs encoding the audit log uses.
+type otlpLogs struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpScope       `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano   string         `json:"timeUnixNano"`
+	SeverityNumber int            `json:"severityNumber"`
+	SeverityText   string         `json:"severityText"`
+	Body           otlpAnyValue   `json:"body"`
+	Attributes     []otlpKeyValue `json:"attributes"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+// otlpAnyValue holds one of the OTLP value kinds. Integers are encoded as strings, as
+// the OTLP/JSON mapping requires for 64-bit values.
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func otlpString(key, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: &value}}
+}
+
+func otlpInt(key string, value int) otlpKeyValue {
+	s := strconv.Itoa(value)
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{IntValue: &s}}
+}
+
+func otlpDouble(key string, value float64) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{DoubleValue: &value}}
+}
+
+// otlpLogsFor wraps e in an OTLP logs export request. Attribute names follow the
+// OpenTelemetry HTTP semantic conventions where one exists.
+func otlpLogsFor(e AuditEvent) otlpLogs {
+	severity, severityText := 9, "INFO"
+	if e.Decision != AuditAllowed {
+		severity, severityText = 13, "WARN"
+	}
+
+	attrs := []otlpKeyValue{
+		otlpString("http.request.method", e.Method),
+		otlpString("server.address", e.Host),
+		otlpString("sidecar.mode", e.Mode),
+		otlpString("sidecar.decision", e.Decision),
+		otlpInt("http.response.status_code", e.Status),
+		otlpDouble("sidecar.latency_ms", e.LatencyMS),
+	}
+	if e.Port != 0 {
+		attrs = append(attrs, otlpInt("server.port", e.Port))
+	}
+	if e.Path != "" {
+		attrs = append(attrs, otlpString("url.path", e.Path))
+	}
+	if e.Rule != "" {
+		attrs = append(attrs, otlpString("sidecar.rule", e.Rule))
+	}
+	if e.Reason != "" {
+		attrs = append(attrs, otlpString("sidecar.reason", e.Reason))
+	}
+	if e.Tunnel != "" {
+		attrs = append(attrs, otlpString("sidecar.tunnel", e.Tunnel))
+	}
+
+	resource := []otlpKeyValue{otlpString("service.name", "agent-sidecar")}
+	if e.Agent != "" {
+		resource = append(resource, otlpString("kagenti.agent_card", e.Agent))
+	}
+	body := "outbound request " + e.Decision
+	return otlpLogs{ResourceLogs: []otlpResourceLogs{{
+		Resource: otlpResource{Attributes: resource},
+		ScopeLogs: []otlpScopeLogs{{
+			Scope: otlpScope{Name: "agent-sidecar/audit"},
+			LogRecords: []otlpLogRecord{{
+				TimeUnixNano:   strconv.FormatInt(e.Time.UnixNano(), 10),
+				SeverityNumber: severity,
+				SeverityText:   severityText,
+				Body:           otlpAnyValue{StringValue: &body},
+				Attributes:     attrs,
+			}},
+		}},
+	}}}
+}
@@ -0,0 +1,200 @@
+package sidecar
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/agentoperations/agent-access-control/pkg/sidecarconfig"
+)
+
+func readAuditEvents(t *testing.T, buf *bytes.Buffer) []AuditEvent {
+	t.Helper()
+	var events []AuditEvent
+	scanner := bufio.NewScanner(bytes.NewReader(buf.Bytes()))
+	for scanner.Scan() {
+		var e AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("expected JSON audit line, got %q: %v", scanner.Text(), err)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestProxy_AuditLog(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer upstream.Close()
+
+	secretFile := filepath.Join(t.TempDir(), "api-key")
+	if err := os.WriteFile(secretFile, []byte("sk-do-not-log"), 0o600); err != nil {
+		t.Fatalf("failed to write secret: %v", err)
+	}
+	cfg := testProxyConfig(t)
+	cfg.Agent = "weather"
+	cfg.External.Rules[0] = sidecarconfig.ExternalRule{Host: "127.0.0.1", Mode: sidecarconfig.ModeSecret, SecretFile: secretFile}
+
+	var jsonOut, otlpOut bytes.Buffer
+	proxy := httptest.NewServer(&Proxy{Config: cfg, Transport: upstreamTransport(), Log: logr.Discard(), Audit: NewAuditLog(&jsonOut, &otlpOut)})
+	defer proxy.Close()
+	client := proxyClient(t, proxy)
+
+	resp, err := client.Get("http://" + upstream.Listener.Addr().String() + "/v1/models?api_key=sk-query-secret")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	resp, err = client.Get("http://blocked.example.com/repos")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	events := readAuditEvents(t, &jsonOut)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 audit records, got %d: %s", len(events), jsonOut.String())
+	}
+
+	allowed := events[0]
+	if allowed.Agent != "weather" || allowed.Host != "127.0.0.1" || allowed.Path != "/v1/models" ||
+		allowed.Rule != "127.0.0.1" || allowed.Mode != sidecarconfig.ModeSecret ||
+		allowed.Decision != AuditAllowed || allowed.Status != http.StatusAccepted || allowed.Method != http.MethodGet {
+		t.Errorf("unexpected allowed record %+v", allowed)
+	}
+	if allowed.LatencyMS <= 0 || allowed.Time.IsZero() {
+		t.Errorf("expected latency and time to be recorded, got %+v", allowed)
+	}
+
+	denied := events[1]
+	if denied.Host != "blocked.example.com" || denied.Decision != AuditDenied ||
+		denied.Status != http.StatusForbidden || denied.Reason == "" {
+		t.Errorf("unexpected denied record %+v", denied)
+	}
+
+	for _, out := range []string{jsonOut.String(), otlpOut.String()} {
+		if strings.Contains(out, "sk-do-not-log") || strings.Contains(out, "sk-query-secret") {
+			t.Errorf("audit log leaked a credential: %s", out)
+		}
+	}
+
+	t.Run("otlp", func(t *testing.T) {
+		lines := strings.Split(strings.TrimSpace(otlpOut.String()), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("expected 2 OTLP lines, got %d", len(lines))
+		}
+		var logs otlpLogs
+		if err := json.Unmarshal([]byte(lines[1]), &logs); err != nil {
+			t.Fatalf("expected OTLP/JSON line: %v", err)
+		}
+		record := logs.ResourceLogs[0].ScopeLogs[0].LogRecords[0]
+		if record.SeverityText != "WARN" || record.TimeUnixNano == "" {
+			t.Errorf("unexpected OTLP record %+v", record)
+		}
+		attrs := map[string]otlpAnyValue{}
+		for _, kv := range record.Attributes {
+			attrs[kv.Key] = kv.Value
+		}
+		if v := attrs["server.address"].StringValue; v == nil || *v != "blocked.example.com" {
+			t.Errorf("expected server.address attribute, got %+v", attrs)
+		}
+		if v := attrs["http.response.status_code"].IntValue; v == nil || *v != "403" {
+			t.Errorf("expected status code attribute, got %+v", attrs)
+		}
+	})
+}
+
+// lockedBuffer is a bytes.Buffer safe to read while the proxy writes to it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) events(t *testing.T) []AuditEvent {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return readAuditEvents(t, bytes.NewBuffer(b.buf.Bytes()))
+}
+
+func TestProxy_AuditTunnel(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer upstream.Close()
+	go func() {
+		conn, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	var out lockedBuffer
+	proxy := httptest.NewServer(&Proxy{Config: testProxyConfig(t), Log: logr.Discard(), Audit: NewAuditLog(&out, nil)})
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial proxy: %v", err)
+	}
+	defer conn.Close()
+	target := upstream.Addr().String()
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("failed to read CONNECT response: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the tunnel to be established, got %d", resp.StatusCode)
+	}
+
+	waitForEvents := func(n int) []AuditEvent {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			events := out.events(t)
+			if len(events) >= n || time.Now().After(deadline) {
+				return events
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// The tunnel is recorded while it is still open.
+	events := waitForEvents(1)
+	if len(events) != 1 || events[0].Tunnel != TunnelOpened || events[0].Method != http.MethodConnect ||
+		events[0].Decision != AuditAllowed || events[0].Status != http.StatusOK {
+		t.Fatalf("expected one record for the open tunnel, got %+v", events)
+	}
+
+	conn.Close()
+	events = waitForEvents(2)
+	if len(events) != 2 || events[1].Tunnel != TunnelClosed || events[1].Host != events[0].Host ||
+		events[1].LatencyMS < events[0].LatencyMS {
+		t.Errorf("expected a second record when the tunnel closes, got %+v", events)
+	}
+}
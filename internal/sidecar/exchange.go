@@ -0,0 +1,293 @@
+package sidecar
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/agentoperations/agent-access-control/pkg/sidecarconfig"
+)
+
+const (
+	// GrantTypeTokenExchange is the RFC 8693 grant type.
+	GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+	tokenTypeJWT         = "urn:ietf:params:oauth:token-type:jwt"
+	tokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+
+	// exchangeExpirySkew is how long before exp an exchanged token stops being reused.
+	exchangeExpirySkew = 30 * time.Second
+
+	// exchangeRequestTimeout bounds a shared exchange, which outlives the request that
+	// started it when that request is cancelled.
+	exchangeRequestTimeout = 30 * time.Second
+
+	maxTokenResponseBytes = 1 << 20
+)
+
+// TokenExchanger trades the agent's token for one scoped to an external audience
+// using OAuth 2.0 Token Exchange (RFC 8693). Exchanged tokens are cached until shortly
+// before they expire, and concurrent exchanges of the same token share one request.
+type TokenExchanger struct {
+	// TokenEndpoint is the authorization server's token endpoint.
+	TokenEndpoint string
+
+	// ClientID and ClientSecret authenticate the sidecar to the token endpoint with
+	// HTTP Basic auth. Both are optional.
+	ClientID     string
+	ClientSecret string
+
+	// SubjectTokenPath is the workload token used when the outbound request carries
+	// no bearer token of its own. Defaults to the in-cluster ServiceAccount token.
+	SubjectTokenPath string
+
+	// HTTPClient is used to call the token endpoint. http.DefaultClient is used when nil.
+	HTTPClient *http.Client
+
+	// now is overridden in tests.
+	now func() time.Time
+
+	exchanges singleflight.Group
+
+	mu    sync.Mutex
+	cache map[string]cachedSecret
+}
+
+// NewTokenExchanger returns an exchanger for the identityProvider block of the sidecar
+// config, reading the client secret from its mounted file.
+func NewTokenExchanger(cfg *sidecarconfig.IdentityProvider) (*TokenExchanger, error) {
+	x := &TokenExchanger{
+		TokenEndpoint: cfg.TokenEndpoint,
+		ClientID:      cfg.ClientID,
+	}
+	if cfg.ClientSecretFile != "" {
+		secret, err := os.ReadFile(cfg.ClientSecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client secret: %w", err)
+		}
+		x.ClientSecret = strings.TrimSpace(string(secret))
+	}
+	return x, nil
+}
+
+// ExchangeError is returned when the token endpoint refuses an exchange or cannot be reached.
+type ExchangeError struct {
+	// StatusCode is the token endpoint's HTTP status, or zero if it could not be reached.
+	StatusCode int
+
+	// Code and Description carry the OAuth error response (RFC 6749 section 5.2).
+	Code        string
+	Description string
+
+	Err error
+}
+
+func (e *ExchangeError) Error() string {
+	switch {
+	case e.Err != nil:
+		return fmt.Sprintf("token exchange failed: %v", e.Err)
+	case e.Description != "":
+		return fmt.Sprintf("token exchange rejected (%d %s): %s", e.StatusCode, e.Code, e.Description)
+	default:
+		return fmt.Sprintf("token exchange rejected (%d %s)", e.StatusCode, e.Code)
+	}
+}
+
+func (e *ExchangeError) Unwrap() error { return e.Err }
+
+// Rejected reports whether the authorization server answered and refused the exchange,
+// as opposed to being unreachable or failing.
+func (e *ExchangeError) Rejected() bool {
+	return e.StatusCode >= 400 && e.StatusCode < 500
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IssuedTokenType  string `json:"issued_token_type"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange returns a token for the rule's audience and scopes. subjectToken is the
+// agent's own bearer token; the workload token is used when it is empty.
+func (x *TokenExchanger) Exchange(ctx context.Context, subjectToken string, rule *sidecarconfig.ExternalRule) (string, error) {
+	if subjectToken == "" {
+		data, err := os.ReadFile(x.subjectTokenPath())
+		if err != nil {
+			return "", &ExchangeError{Err: fmt.Errorf("failed to read workload token: %w", err)}
+		}
+		subjectToken = strings.TrimSpace(string(data))
+	}
+
+	cacheKey := strings.Join([]string{subjectToken, rule.Audience, strings.Join(rule.Scopes, " ")}, "\x00")
+
+	x.mu.Lock()
+	cached, ok := x.cache[cacheKey]
+	x.mu.Unlock()
+	if ok && x.clock().Before(cached.expires) {
+		return cached.value, nil
+	}
+
+	ch := x.exchanges.DoChan(cacheKey, func() (interface{}, error) {
+		exchangeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), exchangeRequestTimeout)
+		defer cancel()
+		return x.exchange(exchangeCtx, subjectToken, rule, cacheKey)
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return "", res.Err
+		}
+		return res.Val.(string), nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// exchange calls the token endpoint and caches the issued token under cacheKey.
+func (x *TokenExchanger) exchange(ctx context.Context, subjectToken string, rule *sidecarconfig.ExternalRule, cacheKey string) (string, error) {
+	resp, err := x.requestToken(ctx, subjectToken, rule)
+	if err != nil {
+		return "", err
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	now := x.clock()
+	expires := tokenExpiry(resp, now).Add(-exchangeExpirySkew)
+	if x.cache == nil {
+		x.cache = map[string]cachedSecret{}
+	}
+	for k, v := range x.cache {
+		if !now.Before(v.expires) {
+			delete(x.cache, k)
+		}
+	}
+	if now.Before(expires) {
+		x.cache[cacheKey] = cachedSecret{value: resp.AccessToken, expires: expires}
+	}
+	return resp.AccessToken, nil
+}
+
+// requestToken calls the token endpoint with the RFC 8693 grant.
+func (x *TokenExchanger) requestToken(ctx context.Context, subjectToken string, rule *sidecarconfig.ExternalRule) (*tokenResponse, error) {
+	form := url.Values{
+		"grant_type":         {GrantTypeTokenExchange},
+		"subject_token":      {subjectToken},
+		"subject_token_type": {subjectTokenType(subjectToken)},
+	}
+	if rule.Audience != "" {
+		form.Set("audience", rule.Audience)
+	}
+	if len(rule.Scopes) > 0 {
+		form.Set("scope", strings.Join(rule.Scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, x.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, &ExchangeError{Err: err}
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if x.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(x.ClientID), url.QueryEscape(x.ClientSecret))
+	}
+
+	httpClient := x.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, &ExchangeError{Err: err}
+	}
+	defer resp.Body.Close()
+
+	var out tokenResponse
+	decodeErr := json.NewDecoder(io.LimitReader(resp.Body, maxTokenResponseBytes)).Decode(&out)
+	if resp.StatusCode != http.StatusOK {
+		return nil, &ExchangeError{StatusCode: resp.StatusCode, Code: out.Error, Description: out.ErrorDescription}
+	}
+	if decodeErr != nil {
+		return nil, &ExchangeError{StatusCode: resp.StatusCode, Err: fmt.Errorf("failed to decode token response: %w", decodeErr)}
+	}
+	if out.AccessToken == "" {
+		return nil, &ExchangeError{StatusCode: resp.StatusCode, Err: fmt.Errorf("token response carried no access_token")}
+	}
+	return &out, nil
+}
+
+func (x *TokenExchanger) subjectTokenPath() string {
+	if x.SubjectTokenPath != "" {
+		return x.SubjectTokenPath
+	}
+	return DefaultServiceAccountTokenPath
+}
+
+func (x *TokenExchanger) clock() time.Time {
+	if x.now != nil {
+		return x.now()
+	}
+	return time.Now()
+}
+
+// subjectTokenType reports a JWT for three-part tokens and an opaque access token otherwise.
+func subjectTokenType(token string) string {
+	if strings.Count(token, ".") == 2 {
+		return tokenTypeJWT
+	}
+	return tokenTypeAccessToken
+}
+
+// tokenExpiry returns when an exchanged token expires, preferring the JWT exp claim
+// and falling back to expires_in. Tokens with neither are not cached.
+func tokenExpiry(resp *tokenResponse, now time.Time) time.Time {
+	if exp, ok := jwtExpiry(resp.AccessToken); ok {
+		return exp
+	}
+	if resp.ExpiresIn > 0 {
+		return now.Add(time.Duration(resp.ExpiresIn) * time.Second)
+	}
+	return now
+}
+
+// jwtExpiry reads the exp claim of a JWT without verifying it; the token was just
+// issued to us by the authorization server, so its signature is not our concern here.
+func jwtExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header, if any.
+func bearerToken(h http.Header) string {
+	scheme, token, ok := strings.Cut(h.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
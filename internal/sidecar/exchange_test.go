@@ -0,0 +1,329 @@
+package sidecar
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/agentoperations/agent-access-control/pkg/sidecarconfig"
+)
+
+func testJWT(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("failed to marshal claims: %v", err)
+	}
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(`{"alg":"none"}`)) + "." + enc.EncodeToString(payload) + ".sig"
+}
+
+// fakeTokenEndpoint issues exchanged tokens for any subject token except "rejected".
+type fakeTokenEndpoint struct {
+	t   *testing.T
+	exp time.Time
+
+	mu       sync.Mutex
+	requests int
+	lastForm map[string]string
+}
+
+func (f *fakeTokenEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+
+	if err := r.ParseForm(); err != nil {
+		f.t.Errorf("failed to parse form: %v", err)
+	}
+	f.lastForm = map[string]string{}
+	for k := range r.PostForm {
+		f.lastForm[k] = r.PostForm.Get(k)
+	}
+	if id, secret, _ := r.BasicAuth(); id != "sidecar" || secret != "client-secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error":"invalid_client"}`)
+		return
+	}
+	if r.PostForm.Get("grant_type") != GrantTypeTokenExchange || r.PostForm.Get("subject_token") == "rejected" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":"invalid_grant","error_description":"subject token not accepted"}`)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":      testJWT(f.t, map[string]interface{}{"aud": r.PostForm.Get("audience"), "exp": f.exp.Unix()}),
+		"issued_token_type": tokenTypeAccessToken,
+		"token_type":        "Bearer",
+	})
+}
+
+func newTestExchanger(t *testing.T, endpoint string, now *time.Time) *TokenExchanger {
+	t.Helper()
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenPath, []byte("workload-token"), 0o600); err != nil {
+		t.Fatalf("failed to write token: %v", err)
+	}
+	return &TokenExchanger{
+		TokenEndpoint:    endpoint,
+		ClientID:         "sidecar",
+		ClientSecret:     "client-secret",
+		SubjectTokenPath: tokenPath,
+		now:              func() time.Time { return *now },
+	}
+}
+
+func TestTokenExchanger_Exchange(t *testing.T) {
+	now := time.Now()
+	idp := &fakeTokenEndpoint{t: t, exp: now.Add(5 * time.Minute)}
+	srv := httptest.NewServer(idp)
+	defer srv.Close()
+
+	x := newTestExchanger(t, srv.URL, &now)
+	rule := &sidecarconfig.ExternalRule{Host: "api.github.com", Mode: sidecarconfig.ModeExchange, Audience: "github-tools", Scopes: []string{"repo:read", "issues:write"}}
+	ctx := context.Background()
+
+	token, err := x.Exchange(ctx, "", rule)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp, ok := jwtExpiry(token); !ok || exp.Unix() != idp.exp.Unix() {
+		t.Errorf("unexpected exchanged token %q", token)
+	}
+	if idp.lastForm["subject_token"] != "workload-token" || idp.lastForm["subject_token_type"] != tokenTypeAccessToken {
+		t.Errorf("expected workload token as subject, got %v", idp.lastForm)
+	}
+	if idp.lastForm["audience"] != "github-tools" || idp.lastForm["scope"] != "repo:read issues:write" {
+		t.Errorf("unexpected audience/scope %v", idp.lastForm)
+	}
+
+	// Cached until shortly before exp.
+	now = now.Add(4 * time.Minute)
+	if _, err := x.Exchange(ctx, "", rule); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if idp.requests != 1 {
+		t.Errorf("expected cached token, got %d requests", idp.requests)
+	}
+	now = now.Add(45 * time.Second)
+	if _, err := x.Exchange(ctx, "", rule); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if idp.requests != 2 {
+		t.Errorf("expected a fresh exchange near exp, got %d requests", idp.requests)
+	}
+
+	// The agent's own token is exchanged when present, and cached separately.
+	agentToken := testJWT(t, map[string]interface{}{"sub": "system:serviceaccount:default:weather-agent"})
+	if _, err := x.Exchange(ctx, agentToken, rule); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if idp.requests != 3 || idp.lastForm["subject_token"] != agentToken || idp.lastForm["subject_token_type"] != tokenTypeJWT {
+		t.Errorf("expected agent JWT as subject, got %v", idp.lastForm)
+	}
+}
+
+func TestTokenExchanger_ConcurrentExchanges(t *testing.T) {
+	now := time.Now()
+	idp := &fakeTokenEndpoint{t: t, exp: now.Add(5 * time.Minute)}
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.PostForm.Get("audience") == "slow" {
+			<-release
+		}
+		idp.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	x := newTestExchanger(t, srv.URL, &now)
+	slow := &sidecarconfig.ExternalRule{Host: "slow.example.com", Mode: sidecarconfig.ModeExchange, Audience: "slow"}
+	fast := &sidecarconfig.ExternalRule{Host: "api.github.com", Mode: sidecarconfig.ModeExchange, Audience: "github-tools"}
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := x.Exchange(ctx, "", slow); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+
+	// An exchange for another audience does not wait behind the stalled one.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := x.Exchange(ctx, "", fast); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an unrelated exchange not to wait for a slow token endpoint")
+	}
+
+	close(release)
+	wg.Wait()
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	if idp.requests != 2 {
+		t.Errorf("expected concurrent exchanges of one token to share a request, got %d requests", idp.requests)
+	}
+}
+
+func TestNewTokenExchanger(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "client-secret")
+	if err := os.WriteFile(secretFile, []byte("client-secret\n"), 0o600); err != nil {
+		t.Fatalf("failed to write secret: %v", err)
+	}
+
+	x, err := NewTokenExchanger(&sidecarconfig.IdentityProvider{TokenEndpoint: "https://idp.example.com/token", ClientID: "sidecar", ClientSecretFile: secretFile})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if x.ClientSecret != "client-secret" || x.ClientID != "sidecar" {
+		t.Errorf("unexpected exchanger %+v", x)
+	}
+
+	if _, err := NewTokenExchanger(&sidecarconfig.IdentityProvider{ClientSecretFile: filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Error("expected error for missing client secret file")
+	}
+}
+
+func TestTokenExchanger_Errors(t *testing.T) {
+	now := time.Now()
+	srv := httptest.NewServer(&fakeTokenEndpoint{t: t, exp: now.Add(time.Hour)})
+	defer srv.Close()
+	rule := &sidecarconfig.ExternalRule{Host: "api.github.com", Mode: sidecarconfig.ModeExchange, Audience: "github-tools"}
+
+	x := newTestExchanger(t, srv.URL, &now)
+	_, err := x.Exchange(context.Background(), "rejected", rule)
+	xerr, ok := err.(*ExchangeError)
+	if !ok || !xerr.Rejected() || xerr.Code != "invalid_grant" {
+		t.Errorf("expected rejected invalid_grant ExchangeError, got %v", err)
+	}
+
+	unreachable := newTestExchanger(t, "http://127.0.0.1:1/token", &now)
+	_, err = unreachable.Exchange(context.Background(), "", rule)
+	xerr, ok = err.(*ExchangeError)
+	if !ok || xerr.Rejected() {
+		t.Errorf("expected non-rejected ExchangeError, got %v", err)
+	}
+}
+
+func TestProxy_InjectsExchangedToken(t *testing.T) {
+	now := time.Now()
+	idp := httptest.NewServer(&fakeTokenEndpoint{t: t, exp: now.Add(time.Hour)})
+	defer idp.Close()
+
+	var gotAuth string
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+	}))
+	defer upstream.Close()
+
+	cfg := testProxyConfig(t)
+	cfg.External.Rules = append(cfg.External.Rules, sidecarconfig.ExternalRule{
+		Host: "localhost", Mode: sidecarconfig.ModeExchange, Audience: "upstream", Header: "Authorization", HeaderPrefix: "Bearer ",
+	})
+	proxy := httptest.NewServer(&Proxy{Config: cfg, Exchanger: newTestExchanger(t, idp.URL, &now), Transport: upstreamTransport(), Log: logr.Discard()})
+	defer proxy.Close()
+
+	// The agent asks for http://; the proxy must only send the exchanged token over TLS.
+	upstreamURL := "http://localhost:" + upstream.URL[len("https://127.0.0.1:"):]
+
+	resp, err := proxyClient(t, proxy).Get(upstreamURL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if exp, ok := jwtExpiry(gotAuth[len("Bearer "):]); !ok || exp.Unix() != now.Add(time.Hour).Unix() {
+		t.Errorf("expected exchanged token to be injected, got %q", gotAuth)
+	}
+
+	t.Run("rejected exchange", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, upstreamURL, nil)
+		req.Header.Set("Authorization", "Bearer rejected")
+		resp, err := proxyClient(t, proxy).Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var body proxyError
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("expected JSON error body: %v", err)
+		}
+		if resp.StatusCode != http.StatusForbidden || body.Error != "token_exchange_failed" {
+			t.Errorf("expected 403 token_exchange_failed, got %d %+v", resp.StatusCode, body)
+		}
+	})
+}
+
+func TestProxy_DropsAgentAuthorization(t *testing.T) {
+	now := time.Now()
+	idp := httptest.NewServer(&fakeTokenEndpoint{t: t, exp: now.Add(time.Hour)})
+	defer idp.Close()
+
+	var gotHeaders http.Header
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeaders = r.Header.Clone()
+	}))
+	defer upstream.Close()
+	upstreamURL := "http://localhost:" + upstream.URL[len("https://127.0.0.1:"):]
+
+	secretFile := filepath.Join(t.TempDir(), "api-key")
+	if err := os.WriteFile(secretFile, []byte("sk-test"), 0o600); err != nil {
+		t.Fatalf("failed to write secret: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		rule sidecarconfig.ExternalRule
+	}{
+		{"exchange", sidecarconfig.ExternalRule{Host: "localhost", Mode: sidecarconfig.ModeExchange, Audience: "upstream", Header: "X-Api-Token"}},
+		{"secret", sidecarconfig.ExternalRule{Host: "localhost", Mode: sidecarconfig.ModeSecret, SecretFile: secretFile, Header: "X-Api-Token"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testProxyConfig(t)
+			cfg.External.Rules = append(cfg.External.Rules, tt.rule)
+			proxy := httptest.NewServer(&Proxy{Config: cfg, Exchanger: newTestExchanger(t, idp.URL, &now), Transport: upstreamTransport(), Log: logr.Discard()})
+			defer proxy.Close()
+
+			req, _ := http.NewRequest(http.MethodGet, upstreamURL, nil)
+			req.Header.Set("Authorization", "Bearer user-token")
+			resp, err := proxyClient(t, proxy).Do(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected 200, got %d", resp.StatusCode)
+			}
+			if got := gotHeaders.Get("Authorization"); got != "" {
+				t.Errorf("expected the agent's bearer token to be dropped, upstream got %q", got)
+			}
+			if gotHeaders.Get("X-Api-Token") == "" {
+				t.Error("expected the credential in X-Api-Token")
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package sidecar
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-logr/logr"
+
+	"github.com/agentoperations/agent-access-control/pkg/sidecarconfig"
+)
+
+// InboundProxy is a reverse proxy in front of the agent port. It forwards a request to
+// the agent on the loopback interface only if it carries a bearer token that the
+// issuer signed for one of the agent's audiences, on behalf of an allowed subject. This
+// repeats the gateway's check inside the pod, so traffic that reaches the pod directly
+// is still authenticated.
+//
+// Config and Verifier are the initial settings; a ConfigWatcher replaces them through
+// Apply.
+type InboundProxy struct {
+	// Config is the sidecar configuration. Requests are refused with 503 while it has
+	// no inbound block.
+	Config *sidecarconfig.Config
+
+	// Verifier checks token signatures against the issuer's JWKS.
+	Verifier *JWKSVerifier
+
+	// Transport forwards requests to the agent. http.DefaultTransport is used when nil.
+	Transport http.RoundTripper
+
+	// Log receives proxy diagnostics.
+	Log logr.Logger
+
+	runtime atomic.Pointer[Runtime]
+}
+
+// Apply atomically replaces the runtime used for new requests.
+func (p *InboundProxy) Apply(rt *Runtime) {
+	p.runtime.Store(rt)
+}
+
+func (p *InboundProxy) current() *Runtime {
+	if rt := p.runtime.Load(); rt != nil {
+		return rt
+	}
+	return &Runtime{Config: p.Config, Verifier: p.Verifier}
+}
+
+// inboundError is the JSON body returned for inbound requests the sidecar refuses.
+type inboundError struct {
+	Error  string `json:"error"`
+	Reason string `json:"reason"`
+}
+
+// ServeHTTP implements http.Handler.
+func (p *InboundProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt := p.current()
+	inbound := rt.Config.Inbound
+	if inbound == nil || rt.Verifier == nil {
+		writeInboundError(w, http.StatusServiceUnavailable, "inbound_not_configured",
+			"the sidecar config has no inbound block")
+		return
+	}
+
+	token := bearerToken(r.Header)
+	if token == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="agent"`)
+		writeInboundError(w, http.StatusUnauthorized, "invalid_token", "request carries no bearer token")
+		return
+	}
+	claims, err := rt.Verifier.Verify(r.Context(), token)
+	if err != nil {
+		if errors.Is(err, ErrJWKSUnavailable) {
+			p.Log.Error(err, "Failed to fetch signing keys", "issuer", inbound.Issuer)
+			writeInboundError(w, http.StatusServiceUnavailable, "jwks_unavailable", err.Error())
+			return
+		}
+		p.Log.Info("Rejected inbound request", "reason", err.Error())
+		w.Header().Set("WWW-Authenticate", `Bearer realm="agent", error="invalid_token"`)
+		writeInboundError(w, http.StatusUnauthorized, "invalid_token", err.Error())
+		return
+	}
+	if reason := authorizeInbound(inbound, claims); reason != "" {
+		p.Log.Info("Rejected inbound request", "sub", claims.Subject, "reason", reason)
+		writeInboundError(w, http.StatusForbidden, "forbidden", reason)
+		return
+	}
+
+	upstream := "127.0.0.1:" + strconv.Itoa(inbound.UpstreamPort)
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL.Scheme = "http"
+			pr.Out.URL.Host = upstream
+			pr.SetXForwarded()
+		},
+		Transport: p.Transport,
+		ErrorHandler: func(w http.ResponseWriter, _ *http.Request, err error) {
+			p.Log.Error(err, "Failed to reach agent", "upstream", upstream)
+			writeInboundError(w, http.StatusBadGateway, "upstream_unavailable", "the agent could not be reached")
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// authorizeInbound checks the token's audience and subject against the inbound block
+// and returns why the request is refused, or "" if it is allowed.
+func authorizeInbound(inbound *sidecarconfig.Inbound, claims *JWTClaims) string {
+	if !slices.ContainsFunc(claims.Audience, func(aud string) bool { return slices.Contains(inbound.Audiences, aud) }) {
+		return fmt.Sprintf("token audience %v does not include any of %v", []string(claims.Audience), inbound.Audiences)
+	}
+	if !slices.ContainsFunc(inbound.AllowedSubjects, func(allowed string) bool { return subjectMatches(allowed, claims.Subject) }) &&
+		!userAllowed(inbound, claims) {
+		return fmt.Sprintf("subject %q is not allowed to call this agent", claims.Subject)
+	}
+	return ""
+}
+
+// userAllowed reports whether one of the token's UserClaims matches an AllowedUsers
+// entry. "*" accepts any caller that is not a ServiceAccount, as it does at the
+// gateway; ServiceAccounts are only admitted through AllowedSubjects.
+func userAllowed(inbound *sidecarconfig.Inbound, claims *JWTClaims) bool {
+	userClaims := inbound.UserClaims
+	if len(userClaims) == 0 {
+		userClaims = []string{"sub"}
+	}
+	for _, user := range inbound.AllowedUsers {
+		if user == "*" {
+			if !strings.HasPrefix(claims.Subject, sidecarconfig.ServiceAccountPrefix) {
+				return true
+			}
+			continue
+		}
+		for _, name := range userClaims {
+			switch v := claimValue(claims.All, name).(type) {
+			case string:
+				if subjectMatches(user, v) {
+					return true
+				}
+			case []interface{}:
+				if slices.ContainsFunc(v, func(item interface{}) bool {
+					s, ok := item.(string)
+					return ok && subjectMatches(user, s)
+				}) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// claimValue returns the claim named by a dotted path such as "realm_access.roles", or
+// nil if the token does not have it.
+func claimValue(claims map[string]interface{}, name string) interface{} {
+	var value interface{} = claims
+	for _, part := range strings.Split(name, ".") {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = obj[part]
+	}
+	return value
+}
+
+// subjectMatches reports whether sub is allowed by the AllowedSubjects entry allowed,
+// which may end in "*" to match any subject with that prefix.
+func subjectMatches(allowed, sub string) bool {
+	if prefix, ok := strings.CutSuffix(allowed, "*"); ok {
+		return strings.HasPrefix(sub, prefix)
+	}
+	return allowed == sub
+}
+
+func writeInboundError(w http.ResponseWriter, status int, code, reason string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(inboundError{Error: code, Reason: reason})
+}
@@ -0,0 +1,354 @@
+package sidecar
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/agentoperations/agent-access-control/pkg/sidecarconfig"
+)
+
+// testIssuer serves an OpenID configuration and JWKS with one RSA and one EC key and
+// signs tokens with them.
+type testIssuer struct {
+	server *httptest.Server
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate EC key: %v", err)
+	}
+	iss := &testIssuer{rsaKey: rsaKey, ecKey: ecKey}
+
+	enc := base64.RawURLEncoding
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"issuer": iss.url(), "jwks_uri": iss.url() + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{
+			{
+				"kty": "RSA", "kid": "rsa-1", "use": "sig",
+				"n": enc.EncodeToString(rsaKey.N.Bytes()),
+				"e": enc.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+			},
+			{
+				"kty": "EC", "kid": "ec-1", "crv": "P-256",
+				"x": enc.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))),
+				"y": enc.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32))),
+			},
+		}})
+	})
+	iss.server = httptest.NewServer(mux)
+	t.Cleanup(iss.server.Close)
+	return iss
+}
+
+func (i *testIssuer) url() string { return i.server.URL }
+
+// sign returns a token with the given claims signed with RS256 or ES256.
+func (i *testIssuer) sign(t *testing.T, alg string, claims map[string]interface{}) string {
+	t.Helper()
+	kid := "rsa-1"
+	if alg == "ES256" {
+		kid = "ec-1"
+	}
+	enc := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var sig []byte
+	var err error
+	switch alg {
+	case "RS256":
+		sig, err = rsa.SignPKCS1v15(rand.Reader, i.rsaKey, crypto.SHA256, digest[:])
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, i.ecKey, digest[:])
+		if err == nil {
+			sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	}
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signingInput + "." + enc.EncodeToString(sig)
+}
+
+func (i *testIssuer) claims(sub, aud string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss": i.url(),
+		"sub": sub,
+		"aud": aud,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestInboundProxy(t *testing.T) {
+	issuer := newTestIssuer(t)
+
+	var gotAuth string
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		_, _ = w.Write([]byte("forecast"))
+	}))
+	defer agent.Close()
+	_, portStr, _ := net.SplitHostPort(agent.Listener.Addr().String())
+	agentPort, _ := strconv.Atoi(portStr)
+
+	// No jwksUrl: the keys are discovered from the issuer's OpenID configuration.
+	cfg := testProxyConfig(t)
+	cfg.Inbound = &sidecarconfig.Inbound{
+		UpstreamPort:    agentPort,
+		Issuer:          issuer.url(),
+		Audiences:       []string{"weather-agent"},
+		AllowedSubjects: []string{"system:serviceaccount:default:orchestrator", "partner-*"},
+	}
+	cfg.Inbound.AllowedUsers = []string{"alice@example.com", "ml-*"}
+	cfg.Inbound.UserClaims = []string{"email", "realm_access.roles"}
+	proxy := httptest.NewServer(&InboundProxy{Config: cfg, Verifier: NewJWKSVerifier(cfg.Inbound), Log: logr.Discard()})
+	defer proxy.Close()
+
+	call := func(token string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/tasks", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	orchestrator := "system:serviceaccount:default:orchestrator"
+	expired := issuer.claims(orchestrator, "weather-agent")
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	wrongIssuer := issuer.claims(orchestrator, "weather-agent")
+	wrongIssuer["iss"] = "https://other.example.com"
+	multiAud := issuer.claims(orchestrator, "")
+	multiAud["aud"] = []string{"gateway", "weather-agent"}
+	alice := issuer.claims("f3a1", "weather-agent")
+	alice["email"] = "alice@example.com"
+	mlUser := issuer.claims("b7c2", "weather-agent")
+	mlUser["realm_access"] = map[string]interface{}{"roles": []string{"viewer", "ml-engineer"}}
+	otherUser := issuer.claims("c9d4", "weather-agent")
+	otherUser["email"] = "bob@example.com"
+	otherUser["realm_access"] = map[string]interface{}{"roles": []string{"viewer"}}
+	tampered := issuer.sign(t, "RS256", issuer.claims(orchestrator, "weather-agent"))
+	tampered = tampered[:len(tampered)-4] + "AAAA"
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"RS256 token", issuer.sign(t, "RS256", issuer.claims(orchestrator, "weather-agent")), http.StatusOK},
+		{"ES256 token", issuer.sign(t, "ES256", issuer.claims(orchestrator, "weather-agent")), http.StatusOK},
+		{"audience array", issuer.sign(t, "RS256", multiAud), http.StatusOK},
+		{"no token", "", http.StatusUnauthorized},
+		{"unsigned token", testJWT(t, issuer.claims(orchestrator, "weather-agent")), http.StatusUnauthorized},
+		{"tampered signature", tampered, http.StatusUnauthorized},
+		{"expired", issuer.sign(t, "RS256", expired), http.StatusUnauthorized},
+		{"wrong issuer", issuer.sign(t, "RS256", wrongIssuer), http.StatusUnauthorized},
+		{"wrong audience", issuer.sign(t, "RS256", issuer.claims(orchestrator, "billing-agent")), http.StatusForbidden},
+		{"subject prefix", issuer.sign(t, "RS256", issuer.claims("partner-ci", "weather-agent")), http.StatusOK},
+		{"subject not allowed", issuer.sign(t, "RS256", issuer.claims("system:serviceaccount:default:intruder", "weather-agent")), http.StatusForbidden},
+		{"user by email", issuer.sign(t, "RS256", alice), http.StatusOK},
+		{"user by nested list claim", issuer.sign(t, "RS256", mlUser), http.StatusOK},
+		{"user not allowed", issuer.sign(t, "RS256", otherUser), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotAuth = ""
+			if got := call(tt.token); got != tt.status {
+				t.Errorf("expected %d, got %d", tt.status, got)
+			}
+			if tt.status == http.StatusOK && gotAuth != "Bearer "+tt.token {
+				t.Errorf("expected the token to be forwarded to the agent, got %q", gotAuth)
+			}
+			if tt.status != http.StatusOK && gotAuth != "" {
+				t.Error("expected a rejected request not to reach the agent")
+			}
+		})
+	}
+}
+
+func TestInboundProxy_NotConfigured(t *testing.T) {
+	proxy := httptest.NewServer(&InboundProxy{Config: testProxyConfig(t), Log: logr.Discard()})
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL + "/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 without an inbound block, got %d", resp.StatusCode)
+	}
+}
+
+func TestJWKSVerifier_Unavailable(t *testing.T) {
+	issuer := newTestIssuer(t)
+	token := issuer.sign(t, "RS256", issuer.claims("sub", "aud"))
+	issuer.server.Close()
+
+	v := &JWKSVerifier{Issuer: issuer.url(), JWKSURL: issuer.url() + "/keys"}
+	if _, err := v.Verify(t.Context(), token); !errors.Is(err, ErrJWKSUnavailable) {
+		t.Errorf("expected ErrJWKSUnavailable, got %v", err)
+	}
+}
+
+func TestJWKSVerifier_ConcurrentFetches(t *testing.T) {
+	issuer := newTestIssuer(t)
+	token := issuer.sign(t, "RS256", issuer.claims("sub", "aud"))
+
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	keys := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		issuer.server.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer keys.Close()
+
+	v := &JWKSVerifier{Issuer: issuer.url(), JWKSURL: keys.URL + "/keys"}
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := v.Verify(t.Context(), token); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+
+	// A caller whose context ends is not held up by the shared fetch.
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := v.Verify(ctx, token); !errors.Is(err, ErrJWKSUnavailable) {
+		t.Errorf("expected ErrJWKSUnavailable once the caller gives up, got %v", err)
+	}
+
+	close(release)
+	wg.Wait()
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("expected concurrent verifications to share one key fetch, got %d", n)
+	}
+}
+
+func TestJWKSVerifier_BacksOffWhileIssuerIsDown(t *testing.T) {
+	issuer := newTestIssuer(t)
+	token := issuer.sign(t, "RS256", issuer.claims("sub", "aud"))
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "rotated"})
+	unknownKid := base64.RawURLEncoding.EncodeToString(header) + "." + strings.Split(token, ".")[1] + ".c2ln"
+
+	var fetches atomic.Int32
+	var down atomic.Bool
+	keys := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if down.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		issuer.server.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer keys.Close()
+
+	now := time.Now()
+	v := &JWKSVerifier{Issuer: issuer.url(), JWKSURL: keys.URL + "/keys", now: func() time.Time { return now }}
+	if _, err := v.Verify(t.Context(), token); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	down.Store(true)
+	now = now.Add(jwksMaxAge + time.Second)
+	if _, err := v.Verify(t.Context(), token); err != nil {
+		t.Fatalf("expected the cached keys to be used when the refetch fails, got %v", err)
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Fatalf("expected the stale key set to be refetched once, got %d fetches", n)
+	}
+
+	now = now.Add(time.Second)
+	if _, err := v.Verify(t.Context(), token); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := v.Verify(t.Context(), unknownKid); err == nil {
+		t.Error("expected a token with an unknown kid to be rejected")
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("expected no refetch within %s of a failed attempt, got %d fetches", jwksMinRefreshInterval, n)
+	}
+
+	now = now.Add(jwksMinRefreshInterval)
+	if _, err := v.Verify(t.Context(), token); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if n := fetches.Load(); n != 3 {
+		t.Errorf("expected a retry once the backoff has passed, got %d fetches", n)
+	}
+
+	t.Run("no cached keys", func(t *testing.T) {
+		fetches.Store(0)
+		v := &JWKSVerifier{Issuer: issuer.url(), JWKSURL: keys.URL + "/keys", now: func() time.Time { return now }}
+		for range 2 {
+			if _, err := v.Verify(t.Context(), token); !errors.Is(err, ErrJWKSUnavailable) {
+				t.Errorf("expected ErrJWKSUnavailable, got %v", err)
+			}
+		}
+		if n := fetches.Load(); n != 1 {
+			t.Errorf("expected the second request to fail without contacting the issuer, got %d fetches", n)
+		}
+	})
+}
+
+func TestAuthorizeInbound_AnyUser(t *testing.T) {
+	inbound := &sidecarconfig.Inbound{
+		Audiences:    []string{"weather-agent"},
+		AllowedUsers: []string{"*"},
+		UserClaims:   []string{"email"},
+	}
+	claims := &JWTClaims{Subject: "f3a1", Audience: audience{"weather-agent"}, All: map[string]interface{}{"sub": "f3a1"}}
+	if reason := authorizeInbound(inbound, claims); reason != "" {
+		t.Errorf("expected \"*\" to accept any user, got %q", reason)
+	}
+
+	inbound.AllowedSubjects = []string{"system:serviceaccount:default:orchestrator"}
+	sa := &JWTClaims{Subject: "system:serviceaccount:default:rogue", Audience: audience{"weather-agent"}, All: map[string]interface{}{"sub": "system:serviceaccount:default:rogue"}}
+	if reason := authorizeInbound(inbound, sa); reason == "" {
+		t.Error("expected \"*\" not to admit a ServiceAccount missing from the allowed subjects")
+	}
+}
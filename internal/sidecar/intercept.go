@@ -0,0 +1,270 @@
+package sidecar
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/agentoperations/agent-access-control/pkg/sidecarconfig"
+)
+
+const (
+	// leafValidity is how long a minted host certificate is valid.
+	leafValidity = 24 * time.Hour
+
+	// leafRenewBefore is how long before expiry a cached host certificate is replaced.
+	leafRenewBefore = time.Hour
+)
+
+// CertAuthority mints certificates for the hosts whose tunnels the proxy intercepts,
+// signed by the CA from the tlsInterception block. The CA is reloaded when its
+// certificate file changes, which also discards the host certificates minted from it.
+type CertAuthority struct {
+	certFile string
+	keyFile  string
+
+	// now is overridden in tests.
+	now func() time.Time
+
+	mu      sync.Mutex
+	modTime time.Time
+	ca      *x509.Certificate
+	caKey   crypto.Signer
+	leafKey *ecdsa.PrivateKey
+	leaves  map[string]*tls.Certificate
+}
+
+// NewCertAuthority loads the CA from the tlsInterception block of the sidecar config.
+func NewCertAuthority(cfg *sidecarconfig.TLSInterception) (*CertAuthority, error) {
+	ca := &CertAuthority{certFile: cfg.CACertFile, keyFile: cfg.CAKeyFile}
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if err := ca.reloadLocked(); err != nil {
+		return nil, err
+	}
+	return ca, nil
+}
+
+// reloadLocked reads the CA again if its certificate file has been modified.
+func (a *CertAuthority) reloadLocked() error {
+	info, err := os.Stat(a.certFile)
+	if err != nil {
+		return fmt.Errorf("failed to read interception CA: %w", err)
+	}
+	if a.ca != nil && info.ModTime().Equal(a.modTime) {
+		return nil
+	}
+
+	pair, err := tls.LoadX509KeyPair(a.certFile, a.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load interception CA: %w", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse interception CA: %w", err)
+	}
+	if !cert.IsCA {
+		return fmt.Errorf("interception certificate %s is not a CA", a.certFile)
+	}
+	signer, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return errors.New("interception CA key cannot sign")
+	}
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate host key: %w", err)
+	}
+	a.ca, a.caKey, a.leafKey, a.modTime = cert, signer, leafKey, info.ModTime()
+	a.leaves = map[string]*tls.Certificate{}
+	return nil
+}
+
+// Certificate returns a certificate for host, minting one if none is cached or the
+// cached one is about to expire.
+func (a *CertAuthority) Certificate(host string) (*tls.Certificate, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.reloadLocked(); err != nil {
+		return nil, err
+	}
+
+	now := a.clock()
+	if leaf, ok := a.leaves[host]; ok {
+		renewAt := leaf.Leaf.NotAfter.Add(-leafRenewBefore)
+		if leaf.Leaf.NotAfter.Equal(a.ca.NotAfter) {
+			// Capped by the CA's own expiry; minting again would not help.
+			renewAt = leaf.Leaf.NotAfter
+		}
+		if now.Before(renewAt) {
+			return leaf, nil
+		}
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	notAfter := now.Add(leafValidity)
+	if notAfter.After(a.ca.NotAfter) {
+		notAfter = a.ca.NotAfter
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, a.ca, &a.leafKey.PublicKey, a.caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mint certificate for %s: %w", host, err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate for %s: %w", host, err)
+	}
+	cert := &tls.Certificate{Certificate: [][]byte{der, a.ca.Raw}, PrivateKey: a.leafKey, Leaf: leaf}
+	a.leaves[host] = cert
+	return cert, nil
+}
+
+func (a *CertAuthority) clock() time.Time {
+	if a.now != nil {
+		return a.now()
+	}
+	return time.Now()
+}
+
+// intercept terminates the CONNECT tunnel with a certificate for its host and serves
+// the HTTP requests inside it as if the agent had sent them to the proxy directly, so
+// each one is decided, credentialed and audited on its own.
+func (p *Proxy) intercept(w http.ResponseWriter, r *http.Request, ca *CertAuthority, established func()) {
+	host := sidecarconfig.NormalizeHost(r.Host)
+	cert, err := ca.Certificate(host)
+	if err != nil {
+		p.Log.Error(err, "Failed to mint interception certificate", "host", host)
+		http.Error(w, "failed to intercept tunnel", http.StatusBadGateway)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "tunnelling not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buf, err := hijacker.Hijack()
+	if err != nil {
+		p.Log.Error(err, "Failed to hijack connection", "host", r.Host)
+		return
+	}
+	defer client.Close()
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		return
+	}
+	established()
+
+	// The agent may send its ClientHello before reading the 200, so read through the
+	// hijacked buffer.
+	conn := tls.Server(&bufferedConn{Conn: client, r: buf.Reader}, &tls.Config{
+		Certificates: []tls.Certificate{*cert},
+		NextProtos:   []string{"http/1.1"},
+		MinVersion:   tls.VersionTLS12,
+	})
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	if err := conn.HandshakeContext(ctx); err != nil {
+		p.Log.V(1).Info("Intercepted TLS handshake failed", "host", host, "error", err.Error())
+		return
+	}
+
+	authority := r.Host
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, inner *http.Request) {
+			// The rule and credential were chosen for the CONNECT authority; a different
+			// Host header would deliver that credential to another virtual host on the
+			// same front end.
+			if sidecarconfig.NormalizeHost(inner.Host) != host {
+				p.Log.Info("Refused misdirected request in intercepted tunnel", "host", host, "requestHost", inner.Host)
+				writeProxyError(w, http.StatusMisdirectedRequest, "misdirected_request", inner.Host, "",
+					"Host header does not match the tunnelled host "+host)
+				return
+			}
+			inner.Host = authority
+			inner.URL.Scheme = "https"
+			inner.URL.Host = authority
+			p.ServeHTTP(w, inner)
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       90 * time.Second,
+		ErrorLog:          log.New(io.Discard, "", 0),
+	}
+	_ = server.Serve(newConnListener(conn))
+}
+
+// connListener is a net.Listener that yields a single connection and then blocks
+// until that connection is closed, so an http.Server can serve an existing connection.
+type connListener struct {
+	conn net.Conn
+	once sync.Once
+	done chan struct{}
+}
+
+func newConnListener(conn net.Conn) *connListener {
+	return &connListener{conn: conn, done: make(chan struct{})}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	var conn net.Conn
+	l.once.Do(func() { conn = &closeNotifyConn{Conn: l.conn, done: l.done} })
+	if conn != nil {
+		return conn, nil
+	}
+	<-l.done
+	return nil, net.ErrClosed
+}
+
+func (l *connListener) Close() error   { return nil }
+func (l *connListener) Addr() net.Addr { return l.conn.LocalAddr() }
+
+// bufferedConn reads from r, which wraps the connection, so that bytes already buffered
+// by the HTTP server are not lost.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+// closeNotifyConn signals its listener when the server closes it.
+type closeNotifyConn struct {
+	net.Conn
+	once sync.Once
+	done chan struct{}
+}
+
+func (c *closeNotifyConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return c.Conn.Close()
+}
@@ -0,0 +1,231 @@
+package sidecar
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/agentoperations/agent-access-control/pkg/sidecarconfig"
+)
+
+// writeTestCA writes a CA certificate and key to dir and returns their paths along with
+// the parsed certificate.
+func writeTestCA(t *testing.T, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "interception-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(30 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	certFile = filepath.Join(dir, "tls.crt")
+	keyFile = filepath.Join(dir, "tls.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	return certFile, keyFile, cert
+}
+
+// newInterceptingProxy starts a proxy with TLS interception enabled for cfg, and returns
+// it with a client that trusts only the interception CA.
+func newInterceptingProxy(t *testing.T, cfg *sidecarconfig.Config, upstream *httptest.Server) (*httptest.Server, *http.Client) {
+	t.Helper()
+	caCert, caKey, ca := writeTestCA(t, t.TempDir())
+	cfg.TLSInterception = &sidecarconfig.TLSInterception{CACertFile: caCert, CAKeyFile: caKey}
+
+	rt, err := NewRuntime(cfg, "test", nil, RuntimeOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p := &Proxy{Transport: upstream.Client().Transport, Log: logr.Discard()}
+	p.Apply(rt)
+	proxy := httptest.NewServer(p)
+	t.Cleanup(proxy.Close)
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+	}}
+	return proxy, client
+}
+
+func TestProxy_InterceptsTLS(t *testing.T) {
+	var gotAuth, gotHost string
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth, gotHost = r.Header.Get("Authorization"), r.Host
+		_, _ = io.WriteString(w, "secure hello")
+	}))
+	defer upstream.Close()
+
+	secretFile := filepath.Join(t.TempDir(), "api-key")
+	if err := os.WriteFile(secretFile, []byte("sk-test"), 0o600); err != nil {
+		t.Fatalf("failed to write secret: %v", err)
+	}
+	cfg := testProxyConfig(t)
+	cfg.External.Rules = []sidecarconfig.ExternalRule{{
+		Host:           "127.0.0.1",
+		Mode:           sidecarconfig.ModeSecret,
+		SecretFile:     secretFile,
+		HeaderPrefix:   "Bearer ",
+		AllowedMethods: []string{"GET"},
+	}}
+	_, client := newInterceptingProxy(t, cfg, upstream)
+
+	resp, err := client.Get(upstream.URL + "/v1/models")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "secure hello" {
+		t.Fatalf("expected upstream response through the intercepted tunnel, got %d %q", resp.StatusCode, body)
+	}
+	if gotAuth != "Bearer sk-test" {
+		t.Errorf("expected the credential to be injected into the HTTPS request, got %q", gotAuth)
+	}
+
+	t.Run("allowlist is enforced inside the tunnel", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodDelete, upstream.URL+"/v1/models", nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden || !strings.Contains(string(body), "method DELETE is not allowed") {
+			t.Errorf("expected 403 for DELETE, got %d %s", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("mismatched Host header is refused", func(t *testing.T) {
+		gotAuth, gotHost = "", ""
+
+		req, _ := http.NewRequest(http.MethodGet, upstream.URL+"/v1/models", nil)
+		req.Host = "fronted.example.com"
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMisdirectedRequest {
+			t.Errorf("expected 421 for a Host header other than the tunnelled host, got %d", resp.StatusCode)
+		}
+		if gotHost != "" || gotAuth != "" {
+			t.Errorf("expected nothing to reach the upstream, got Host %q with credential %q", gotHost, gotAuth)
+		}
+	})
+}
+
+func TestProxy_PassthroughIsNotIntercepted(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "secure hello")
+	}))
+	defer upstream.Close()
+
+	cfg := testProxyConfig(t)
+	cfg.External.Rules = []sidecarconfig.ExternalRule{{Host: "127.0.0.1", Mode: sidecarconfig.ModePassthrough}}
+	_, client := newInterceptingProxy(t, cfg, upstream)
+
+	// The client trusts only the interception CA, so reaching the upstream's own
+	// certificate fails verification, which shows the tunnel was left alone.
+	_, err := client.Get(upstream.URL)
+	if err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("expected the upstream certificate to be presented unmodified, got %v", err)
+	}
+}
+
+func TestCertAuthority_Certificate(t *testing.T) {
+	caCert, caKey, ca := writeTestCA(t, t.TempDir())
+	authority, err := NewCertAuthority(&sidecarconfig.TLSInterception{CACertFile: caCert, CAKeyFile: caKey})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cert, err := authority.Certificate("api.example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: "api.example.com", Roots: roots}); err != nil {
+		t.Errorf("expected minted certificate to verify against the CA: %v", err)
+	}
+	if again, _ := authority.Certificate("api.example.com"); again != cert {
+		t.Error("expected the host certificate to be cached")
+	}
+
+	ipCert, err := authority.Certificate("127.0.0.1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ipCert.Leaf.IPAddresses) != 1 || len(ipCert.Leaf.DNSNames) != 0 {
+		t.Errorf("expected an IP SAN for an IP host, got %+v", ipCert.Leaf)
+	}
+}
+
+func TestWriteTrustBundle(t *testing.T) {
+	dir := t.TempDir()
+	caCert, caKey, ca := writeTestCA(t, dir)
+	cfg := &sidecarconfig.Config{TLSInterception: &sidecarconfig.TLSInterception{CACertFile: caCert, CAKeyFile: caKey}}
+
+	bundlePath := filepath.Join(dir, "trust", "ca-bundle.crt")
+	if err := WriteTrustBundle(cfg, bundlePath); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(bundlePath)
+	if err != nil {
+		t.Fatalf("failed to read bundle: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		t.Fatal("expected bundle to contain certificates")
+	}
+	if _, err := ca.Verify(x509.VerifyOptions{Roots: pool}); err != nil {
+		t.Errorf("expected bundle to include the interception CA: %v", err)
+	}
+	if strings.Contains(string(data), "PRIVATE KEY") {
+		t.Error("expected the CA key to stay out of the bundle")
+	}
+}
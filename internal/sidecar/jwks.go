@@ -0,0 +1,429 @@
+package sidecar
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/agentoperations/agent-access-control/pkg/sidecarconfig"
+)
+
+const (
+	// jwksMaxAge is how long fetched signing keys are used before being refetched.
+	jwksMaxAge = 10 * time.Minute
+
+	// jwksMinRefreshInterval limits how often a stale key set or a token with an
+	// unknown kid can trigger a refetch, so that forged tokens cannot be used to hammer
+	// the issuer and an unreachable issuer does not delay every request.
+	jwksMinRefreshInterval = 30 * time.Second
+
+	// jwksFetchTimeout bounds a key fetch, including OIDC discovery.
+	jwksFetchTimeout = 10 * time.Second
+
+	// jwtClockSkew is the leeway allowed when checking exp and nbf.
+	jwtClockSkew = 30 * time.Second
+
+	maxJWKSResponseBytes = 1 << 20
+)
+
+// ErrJWKSUnavailable is returned when the issuer's signing keys cannot be fetched, as
+// opposed to a token failing validation.
+var ErrJWKSUnavailable = errors.New("signing keys unavailable")
+
+// jwksHTTPClient is used when a verifier has no HTTPClient of its own.
+var jwksHTTPClient = &http.Client{Timeout: jwksFetchTimeout}
+
+// JWTClaims are the registered claims the inbound proxy checks.
+type JWTClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	Expiry    *int64   `json:"exp"`
+	NotBefore *int64   `json:"nbf"`
+
+	// All holds every claim in the token, for matching users on arbitrary claims.
+	All map[string]interface{} `json:"-"`
+}
+
+// audience is the aud claim, which may be a single string or an array (RFC 7519
+// section 4.1.3).
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multi []string
+	if err := json.Unmarshal(data, &multi); err != nil {
+		return fmt.Errorf("aud must be a string or an array of strings")
+	}
+	*a = multi
+	return nil
+}
+
+// JWKSVerifier verifies JWTs signed with one of an issuer's published keys. Keys are
+// cached and refetched periodically, or sooner when a token names an unknown kid.
+// Concurrent refetches share one request, and no lock is held while it is made. After
+// an attempt, successful or not, the next one waits jwksMinRefreshInterval.
+type JWKSVerifier struct {
+	// Issuer is the required iss claim.
+	Issuer string
+
+	// JWKSURL is where the keys are fetched. When empty it is discovered from the
+	// issuer's OpenID configuration on first use.
+	JWKSURL string
+
+	// HTTPClient fetches keys. A client with a ten second timeout is used when nil.
+	HTTPClient *http.Client
+
+	// now is overridden in tests.
+	now func() time.Time
+
+	fetches singleflight.Group
+
+	mu            sync.Mutex
+	keys          map[string]crypto.PublicKey
+	fetched       time.Time
+	lastAttempt   time.Time
+	lastErr       error
+	discoveredURL string
+}
+
+// NewJWKSVerifier returns a verifier for the inbound block of the sidecar config.
+func NewJWKSVerifier(cfg *sidecarconfig.Inbound) *JWKSVerifier {
+	return &JWKSVerifier{Issuer: cfg.Issuer, JWKSURL: cfg.JWKSURL}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks the token's signature, issuer and validity period and returns its
+// claims. Audience and subject are left to the caller.
+func (v *JWKSVerifier) Verify(ctx context.Context, token string) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token is not a signed JWT")
+	}
+	var header jwtHeader
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token signature: %w", err)
+	}
+	hash, err := jwtHash(header.Alg)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := v.keysFor(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no signing key with kid %q", header.Kid)
+	}
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	digest := h.Sum(nil)
+	verified := false
+	for _, key := range keys {
+		if verifyJWTSignature(header.Alg, hash, key, digest, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("token signature is invalid")
+	}
+
+	var claims JWTClaims
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid token claims: %w", err)
+	}
+	if err := decodeJWTSegment(parts[1], &claims.All); err != nil {
+		return nil, fmt.Errorf("invalid token claims: %w", err)
+	}
+	if claims.Issuer != v.Issuer {
+		return nil, fmt.Errorf("token issuer %q is not %q", claims.Issuer, v.Issuer)
+	}
+	now := v.clock()
+	if claims.Expiry == nil {
+		return nil, errors.New("token has no exp claim")
+	}
+	if !now.Before(time.Unix(*claims.Expiry, 0).Add(jwtClockSkew)) {
+		return nil, errors.New("token has expired")
+	}
+	if claims.NotBefore != nil && now.Add(jwtClockSkew).Before(time.Unix(*claims.NotBefore, 0)) {
+		return nil, errors.New("token is not valid yet")
+	}
+	return &claims, nil
+}
+
+// keysFor returns the candidate keys for kid, or every key when the token names none.
+// The key set is refetched when stale or when kid is unknown, unless the last attempt
+// was too recent; while the issuer is down the cached keys are used without waiting.
+func (v *JWKSVerifier) keysFor(ctx context.Context, kid string) ([]crypto.PublicKey, error) {
+	v.mu.Lock()
+	now := v.clock()
+	_, known := v.keys[kid]
+	stale := v.keys == nil || now.Sub(v.fetched) > jwksMaxAge
+	backingOff := !v.lastAttempt.IsZero() && now.Sub(v.lastAttempt) < jwksMinRefreshInterval
+	refetch := (stale || (kid != "" && !known)) && !backingOff
+	keys, lastErr := v.keys, v.lastErr
+	v.mu.Unlock()
+
+	if keys == nil && !refetch {
+		return nil, lastErr
+	}
+
+	if refetch {
+		ch := v.fetches.DoChan("keys", func() (interface{}, error) {
+			fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jwksFetchTimeout)
+			defer cancel()
+			return v.fetchKeys(fetchCtx)
+		})
+		select {
+		case res := <-ch:
+			if res.Err != nil {
+				if keys == nil {
+					return nil, res.Err
+				}
+				// Keep using the keys we have; the issuer may be briefly unreachable.
+			} else {
+				keys = res.Val.(map[string]crypto.PublicKey)
+			}
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %v", ErrJWKSUnavailable, ctx.Err())
+		}
+	}
+
+	if kid != "" {
+		if key, ok := keys[kid]; ok {
+			return []crypto.PublicKey{key}, nil
+		}
+		return nil, nil
+	}
+	all := make([]crypto.PublicKey, 0, len(keys))
+	for _, key := range keys {
+		all = append(all, key)
+	}
+	return all, nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchKeys downloads the key set and caches it, recording the attempt either way.
+// Keys of unsupported types or for encryption are skipped.
+func (v *JWKSVerifier) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	keys, err := v.downloadKeys(ctx)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.lastAttempt, v.lastErr = v.clock(), err
+	if err != nil {
+		return nil, err
+	}
+	v.keys, v.fetched = keys, v.lastAttempt
+	return keys, nil
+}
+
+func (v *JWKSVerifier) downloadKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	v.mu.Lock()
+	jwksURL := v.JWKSURL
+	if jwksURL == "" {
+		jwksURL = v.discoveredURL
+	}
+	v.mu.Unlock()
+	if jwksURL == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		configURL := strings.TrimSuffix(v.Issuer, "/") + "/.well-known/openid-configuration"
+		if err := v.getJSON(ctx, configURL, &discovery); err != nil {
+			return nil, err
+		}
+		if discovery.JWKSURI == "" {
+			return nil, fmt.Errorf("%w: %s advertises no jwks_uri", ErrJWKSUnavailable, configURL)
+		}
+		jwksURL = discovery.JWKSURI
+		v.mu.Lock()
+		v.discoveredURL = jwksURL
+		v.mu.Unlock()
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := v.getJSON(ctx, jwksURL, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+func (v *JWKSVerifier) getJSON(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrJWKSUnavailable, err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	httpClient := v.HTTPClient
+	if httpClient == nil {
+		httpClient = jwksHTTPClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrJWKSUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s returned %d", ErrJWKSUnavailable, url, resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxJWKSResponseBytes)).Decode(out); err != nil {
+		return fmt.Errorf("%w: failed to decode %s: %v", ErrJWKSUnavailable, url, err)
+	}
+	return nil
+}
+
+func (v *JWKSVerifier) clock() time.Time {
+	if v.now != nil {
+		return v.now()
+	}
+	return time.Now()
+}
+
+// publicKey converts an RSA or EC JSON Web Key (RFC 7518 section 6).
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeJWKInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeJWKInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWKInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeJWKInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func decodeJWTSegment(segment string, out interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// jwtHash returns the digest used by a JWS algorithm. Only asymmetric algorithms are
+// accepted, so a token cannot be signed with a key published in the JWKS.
+func jwtHash(alg string) (crypto.Hash, error) {
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		return crypto.SHA256, nil
+	case "RS384", "PS384", "ES384":
+		return crypto.SHA384, nil
+	case "RS512", "PS512", "ES512":
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported signing algorithm %q", alg)
+}
+
+func verifyJWTSignature(alg string, hash crypto.Hash, key crypto.PublicKey, digest, signature []byte) bool {
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(pub, hash, digest, signature) == nil
+		case "PS":
+			return rsa.VerifyPSS(pub, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+	case *ecdsa.PublicKey:
+		if alg[:2] != "ES" {
+			return false
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(pub, digest, r, s)
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package sidecar
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Reasons a request is counted as denied.
+const (
+	deniedByPolicy    = "policy"
+	deniedByRateLimit = "rate_limited"
+)
+
+// Metrics counts the outbound requests a proxy refuses.
+type Metrics struct {
+	denied *prometheus.CounterVec
+}
+
+// NewMetrics creates the proxy metrics and registers them with reg.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		denied: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "agent_sidecar",
+			Name:      "denied_requests_total",
+			Help:      "Outbound requests refused by the sidecar, by reason, mode and matched rule.",
+		}, []string{"reason", "mode", "rule"}),
+	}
+	reg.MustRegister(m.denied)
+	return m
+}
+
+// recordDenied counts a refused request. The rule label is the matched rule, or
+// "default" when the default mode applied; hosts are not used as labels because the
+// set of hosts an agent may try is unbounded.
+func (m *Metrics) recordDenied(reason string, decision Decision) {
+	if m == nil {
+		return
+	}
+	rule := "default"
+	if decision.Rule != nil {
+		rule = describeRule(decision.Rule)
+	}
+	m.denied.WithLabelValues(reason, decision.Mode, rule).Inc()
+}
@@ -0,0 +1,126 @@
+package sidecar
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/agentoperations/agent-access-control/pkg/sidecarconfig"
+)
+
+// mtlsTransport originates TLS to the host of an mtls rule, presenting the rule's client
+// certificate. The certificate and CA bundle are reloaded when their files change, so a
+// rotated kubernetes.io/tls Secret or CA bundle takes effect without a restart.
+type mtlsTransport struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	dial     func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+
+	caMu      sync.Mutex
+	transport *http.Transport
+	caModTime time.Time
+}
+
+// newMTLSTransport builds the transport for an mtls rule. The client certificate and CA
+// bundle are loaded up front so that a missing or malformed Secret fails the request
+// with a clear error rather than an opaque handshake failure.
+func newMTLSTransport(rule *sidecarconfig.ExternalRule, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (*mtlsTransport, error) {
+	t := &mtlsTransport{certFile: rule.ClientCertFile, keyFile: rule.ClientKeyFile, caFile: rule.CACertFile, dial: dial}
+	if _, err := t.clientCertificate(nil); err != nil {
+		return nil, err
+	}
+	if _, err := t.currentTransport(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// currentTransport returns the HTTP transport, rebuilding it with a fresh root pool if
+// the CA bundle has been modified since it was last read. Idle connections verified
+// against the old bundle are closed.
+func (t *mtlsTransport) currentTransport() (*http.Transport, error) {
+	var info os.FileInfo
+	if t.caFile != "" {
+		var err error
+		if info, err = os.Stat(t.caFile); err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+	}
+
+	t.caMu.Lock()
+	defer t.caMu.Unlock()
+	if t.transport != nil && (info == nil || info.ModTime().Equal(t.caModTime)) {
+		return t.transport, nil
+	}
+
+	tlsConfig := &tls.Config{GetClientCertificate: t.clientCertificate, MinVersion: tls.VersionTLS12}
+	if info != nil {
+		pem, err := os.ReadFile(t.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA bundle %s contains no certificates", t.caFile)
+		}
+		tlsConfig.RootCAs = pool
+		t.caModTime = info.ModTime()
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = t.dial
+	transport.TLSClientConfig = tlsConfig
+	if t.transport != nil {
+		t.transport.CloseIdleConnections()
+	}
+	t.transport = transport
+	return t.transport, nil
+}
+
+// clientCertificate returns the client certificate, reloading it if the certificate
+// file has been modified since it was last read.
+func (t *mtlsTransport) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	info, err := os.Stat(t.certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client certificate: %w", err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cert != nil && info.ModTime().Equal(t.modTime) {
+		return t.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client certificate: %w", err)
+	}
+	t.cert, t.modTime = &cert, info.ModTime()
+	return t.cert, nil
+}
+
+// mtlsTransportFor returns the transport for an mtls rule, building it on first use.
+// Transports are shared across config reloads for rules that reference the same files.
+func (p *Proxy) mtlsTransportFor(rule *sidecarconfig.ExternalRule) (http.RoundTripper, error) {
+	key := rule.ClientCertFile + "|" + rule.ClientKeyFile + "|" + rule.CACertFile
+	if t, ok := p.mtls.Load(key); ok {
+		return t.(*mtlsTransport).currentTransport()
+	}
+	t, err := newMTLSTransport(rule, p.dial)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := p.mtls.LoadOrStore(key, t)
+	return actual.(*mtlsTransport).currentTransport()
+}
@@ -0,0 +1,160 @@
+package sidecar
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/agentoperations/agent-access-control/pkg/sidecarconfig"
+)
+
+// writeClientCert writes a self-signed client certificate and key to dir and returns
+// their paths along with the parsed certificate.
+func writeClientCert(t *testing.T, dir, commonName string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	certFile = filepath.Join(dir, "tls.crt")
+	keyFile = filepath.Join(dir, "tls.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	return certFile, keyFile, cert
+}
+
+func TestProxy_OriginatesMTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, clientCert := writeClientCert(t, dir, "weather-agent")
+
+	var gotCN string
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			gotCN = r.TLS.PeerCertificates[0].Subject.CommonName
+		}
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	upstream.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw}), 0o600); err != nil {
+		t.Fatalf("failed to write CA bundle: %v", err)
+	}
+
+	cfg := testProxyConfig(t)
+	cfg.External.Rules = []sidecarconfig.ExternalRule{{
+		Host:           "127.0.0.1",
+		Mode:           sidecarconfig.ModeMTLS,
+		ClientCertFile: certFile,
+		ClientKeyFile:  keyFile,
+		CACertFile:     caFile,
+	}}
+	proxy := httptest.NewServer(&Proxy{Config: cfg, Log: logr.Discard()})
+	defer proxy.Close()
+
+	// The agent speaks plain HTTP to the proxy, which originates TLS upstream.
+	plainURL := "http://" + upstream.Listener.Addr().String() + "/partner"
+	resp, err := proxyClient(t, proxy).Get(plainURL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if gotCN != "weather-agent" {
+		t.Errorf("expected client certificate weather-agent, got %q", gotCN)
+	}
+
+	t.Run("tunnel is refused", func(t *testing.T) {
+		d := Decide(cfg, sidecarconfig.NewTarget(upstream.Listener.Addr().String(), 443, ""))
+		if d.Allowed {
+			t.Errorf("expected CONNECT to an mtls host to be refused, got %+v", d)
+		}
+	})
+
+	t.Run("missing certificate", func(t *testing.T) {
+		cfg := testProxyConfig(t)
+		cfg.External.Rules = []sidecarconfig.ExternalRule{{
+			Host:           "127.0.0.1",
+			Mode:           sidecarconfig.ModeMTLS,
+			ClientCertFile: filepath.Join(dir, "missing.crt"),
+			ClientKeyFile:  keyFile,
+		}}
+		proxy := httptest.NewServer(&Proxy{Config: cfg, Log: logr.Discard()})
+		defer proxy.Close()
+
+		resp, err := proxyClient(t, proxy).Get(plainURL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadGateway {
+			t.Errorf("expected 502 for a missing client certificate, got %d", resp.StatusCode)
+		}
+	})
+	t.Run("rotated CA bundle is reloaded", func(t *testing.T) {
+		// The client certificate did not sign the upstream's, so verification must fail
+		// once the bundle is replaced with it.
+		if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientCert.Raw}), 0o600); err != nil {
+			t.Fatalf("failed to write CA bundle: %v", err)
+		}
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(caFile, later, later); err != nil {
+			t.Fatalf("failed to touch CA bundle: %v", err)
+		}
+
+		resp, err := proxyClient(t, proxy).Get(plainURL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadGateway {
+			t.Errorf("expected 502 once the CA bundle no longer trusts the upstream, got %d", resp.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package sidecar
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/agentoperations/agent-access-control/pkg/sidecarconfig"
+)
+
+// Decision is the outcome of evaluating an outbound request against the config.
+type Decision struct {
+	// Allowed reports whether the request may leave the pod.
+	Allowed bool
+
+	// Mode is the credential handling mode that applies to the request.
+	Mode string
+
+	// Rule is the matched rule, or nil when the gateway or the default mode applied.
+	Rule *sidecarconfig.ExternalRule
+
+	// Reason is a short human-readable explanation of the decision.
+	Reason string
+}
+
+// Decide evaluates an outbound request to t against the configuration. Requests to the
+// agent gateway are always allowed; otherwise the most specific rule for the target
+// applies, including its method and path allowlists, falling back to the external
+// default mode. An empty default mode is treated as deny.
+func Decide(cfg *sidecarconfig.Config, t sidecarconfig.Target) Decision {
+	if cfg.IsGateway(t.Host) {
+		return Decision{Allowed: true, Mode: sidecarconfig.ModePassthrough, Reason: "agent gateway"}
+	}
+
+	if rule := cfg.RuleFor(t); rule != nil {
+		if rule.Mode == sidecarconfig.ModeDeny {
+			return Decision{Mode: sidecarconfig.ModeDeny, Rule: rule, Reason: "host " + t.Host + " is denied by rule " + describeRule(rule)}
+		}
+		if rule.Mode == sidecarconfig.ModeMTLS && t.Method == "" {
+			return Decision{Mode: rule.Mode, Rule: rule, Reason: "host " + t.Host + " requires mtls; send plain HTTP requests so the sidecar can originate TLS"}
+		}
+		if ok, reason := rule.Permits(t); !ok {
+			return Decision{Mode: rule.Mode, Rule: rule, Reason: reason}
+		}
+		return Decision{Allowed: true, Mode: rule.Mode, Rule: rule, Reason: "matched rule " + describeRule(rule)}
+	}
+
+	mode := cfg.External.DefaultMode
+	if mode == "" || mode == sidecarconfig.ModeDeny {
+		return Decision{Mode: sidecarconfig.ModeDeny, Reason: "host " + t.Host + " matches no rule and the default mode is deny"}
+	}
+	return Decision{Allowed: true, Mode: mode, Reason: "default mode " + mode}
+}
+
+// describeRule identifies a rule in decision reasons, e.g. "*.example.com:443/v1".
+func describeRule(rule *sidecarconfig.ExternalRule) string {
+	desc := rule.Host
+	if len(rule.Ports) > 0 {
+		ports := make([]string, len(rule.Ports))
+		for i, port := range rule.Ports {
+			ports[i] = strconv.Itoa(port)
+		}
+		desc += ":" + strings.Join(ports, ",")
+	}
+	if len(rule.Paths) > 0 {
+		desc += strings.Join(rule.Paths, ",")
+	}
+	return desc
+}
+
+// requestTarget returns the destination of a proxy request. CONNECT tunnels default to
+// port 443 and carry no visible method or path.
+func requestTarget(r *http.Request) sidecarconfig.Target {
+	if r.Method == http.MethodConnect {
+		return sidecarconfig.NewTarget(r.Host, 443, "")
+	}
+	port := 80
+	if r.URL.Scheme == "https" {
+		port = 443
+	}
+	t := sidecarconfig.NewTarget(r.URL.Host, port, r.URL.Path)
+	t.Method = r.Method
+	return t
+}
@@ -0,0 +1,455 @@
+package sidecar
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/time/rate"
+
+	"github.com/agentoperations/agent-access-control/pkg/sidecarconfig"
+)
+
+// hopHeaders are connection-scoped headers that must not be forwarded (RFC 9110 section 7.6.1).
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// Proxy is an HTTP forward proxy that allows or denies each outbound request by host
+// according to the sidecar configuration. Plain HTTP requests are forwarded, with
+// credentials injected for vault, exchange and secret rules and TLS originated with a
+// client certificate for mtls rules; HTTPS requests are tunnelled with CONNECT, or, when
+// TLS interception is enabled and the host's rules need to see them, decrypted and
+// handled like plain HTTP requests.
+//
+// Config, Vault and Exchanger are the initial settings. A ConfigWatcher replaces them
+// at runtime through Apply; each request is served entirely from one snapshot.
+type Proxy struct {
+	// Config is the sidecar configuration enforced by the proxy.
+	Config *sidecarconfig.Config
+
+	// Vault supplies credentials for vault rules. Requests matching a vault rule fail
+	// with credential_unavailable when nil.
+	Vault *VaultClient
+
+	// Exchanger performs token exchange for exchange rules. Requests matching an
+	// exchange rule fail with token_exchange_failed when nil.
+	Exchanger *TokenExchanger
+
+	// Transport forwards plain HTTP requests. http.DefaultTransport is used when nil.
+	Transport http.RoundTripper
+
+	// DialContext opens CONNECT tunnels. A net.Dialer with a short timeout is used when nil.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Log receives proxy diagnostics.
+	Log logr.Logger
+
+	// Metrics counts refused requests. Nothing is recorded when nil.
+	Metrics *Metrics
+
+	// Audit receives one record per outbound request. Nothing is recorded when nil.
+	Audit *AuditLog
+
+	runtime atomic.Pointer[Runtime]
+	mtls    sync.Map // client certificate files -> *mtlsTransport
+}
+
+// Runtime is an immutable snapshot of the configuration a proxy enforces together with
+// the credential clients built from it.
+type Runtime struct {
+	Config    *sidecarconfig.Config
+	Vault     *VaultClient
+	Exchanger *TokenExchanger
+	Verifier  *JWKSVerifier
+
+	// Interceptor mints host certificates for intercepted tunnels. Nil unless the
+	// config enables TLS interception.
+	Interceptor *CertAuthority
+
+	// Hash identifies the config.yaml the snapshot was loaded from.
+	Hash string
+
+	limiters map[string]*rate.Limiter // rule and limit -> token bucket
+}
+
+// Apply atomically replaces the runtime used for new requests. Requests already in
+// flight finish with the snapshot they started with. A runtime not built by NewRuntime
+// takes over the rate limit buckets of unchanged rules from the one it replaces.
+func (p *Proxy) Apply(rt *Runtime) {
+	if rt.limiters == nil {
+		rt.limiters = newLimiters(rt.Config, p.current())
+	}
+	p.runtime.Store(rt)
+}
+
+// current returns the applied runtime, or one built from the initial fields. The
+// initial runtime is stored on first use so its rate limit buckets persist.
+func (p *Proxy) current() *Runtime {
+	if rt := p.runtime.Load(); rt != nil {
+		return rt
+	}
+	rt := &Runtime{Config: p.Config, Vault: p.Vault, Exchanger: p.Exchanger}
+	rt.limiters = newLimiters(rt.Config, nil)
+	if !p.runtime.CompareAndSwap(nil, rt) {
+		return p.runtime.Load()
+	}
+	return rt
+}
+
+// proxyError is the JSON body returned for requests the proxy refuses or cannot complete.
+type proxyError struct {
+	Error  string `json:"error"`
+	Host   string `json:"host"`
+	Mode   string `json:"mode"`
+	Reason string `json:"reason"`
+}
+
+// ServeHTTP implements http.Handler.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect && r.URL.Host == "" {
+		http.Error(w, "sidecar proxy only accepts proxy requests", http.StatusBadRequest)
+		return
+	}
+
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w}
+	rt := p.current()
+	target := requestTarget(r)
+	event := func(decision Decision, outcome string) AuditEvent {
+		e := AuditEvent{
+			Time:      start.UTC(),
+			Agent:     rt.Config.Agent,
+			Method:    r.Method,
+			Host:      target.Host,
+			Port:      target.Port,
+			Path:      target.Path,
+			Mode:      decision.Mode,
+			Decision:  outcome,
+			Status:    rec.status,
+			LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+		}
+		if decision.Rule != nil {
+			e.Rule = describeRule(decision.Rule)
+		}
+		if outcome != AuditAllowed {
+			e.Reason = decision.Reason
+		}
+		return e
+	}
+
+	var opened bool
+	established := func(decision Decision) {
+		opened = true
+		e := event(decision, AuditAllowed)
+		e.Tunnel = TunnelOpened
+		p.Audit.Record(e)
+	}
+	decision, outcome := p.serve(rec, r, rt, target, established)
+
+	e := event(decision, outcome)
+	if opened {
+		e.Tunnel = TunnelClosed
+	}
+	p.Audit.Record(e)
+}
+
+// serve enforces the decision for one request and reports the audit outcome. For a
+// CONNECT request, established is called once the tunnel is open.
+func (p *Proxy) serve(w http.ResponseWriter, r *http.Request, rt *Runtime, target sidecarconfig.Target, established func(Decision)) (Decision, string) {
+	host := r.Host
+	if r.Method != http.MethodConnect {
+		host = r.URL.Host
+	}
+
+	if r.Method == http.MethodConnect && rt.Interceptor != nil && rt.Config.Intercepts(target) {
+		decision := Decision{Allowed: true, Mode: sidecarconfig.ModePassthrough, Reason: "intercepted; requests inside the tunnel are decided individually"}
+		if rule := rt.Config.RuleFor(target); rule != nil {
+			decision.Mode, decision.Rule = rule.Mode, rule
+		}
+		p.intercept(w, r, rt.Interceptor, func() { established(decision) })
+		return decision, AuditAllowed
+	}
+
+	decision := Decide(rt.Config, target)
+	if !decision.Allowed {
+		p.Log.Info("Denied outbound request", "host", host, "reason", decision.Reason)
+		p.Metrics.recordDenied(deniedByPolicy, decision)
+		writeDenial(w, host, decision)
+		return decision, AuditDenied
+	}
+
+	if decision.Rule != nil && decision.Rule.RateLimit != nil {
+		if ok, delay := rt.takeToken(decision.Rule); !ok {
+			decision.Reason = "rate limit for " + describeRule(decision.Rule) + " exceeded"
+			p.Log.V(1).Info("Rate limited outbound request", "host", host, "rule", describeRule(decision.Rule))
+			p.Metrics.recordDenied(deniedByRateLimit, decision)
+			w.Header().Set("Retry-After", retryAfterSeconds(delay))
+			writeProxyError(w, http.StatusTooManyRequests, "rate_limited", host, decision.Mode, decision.Reason)
+			return decision, AuditRateLimited
+		}
+	}
+
+	if r.Method == http.MethodConnect {
+		if decision.Mode == sidecarconfig.ModeVault || decision.Mode == sidecarconfig.ModeExchange || decision.Mode == sidecarconfig.ModeSecret {
+			p.Log.V(1).Info("Tunnelling host without credential injection", "host", host, "mode", decision.Mode)
+		}
+		p.tunnel(w, r, func() { established(decision) })
+		return decision, AuditAllowed
+	}
+	p.forward(w, r, rt, decision)
+	return decision, AuditAllowed
+}
+
+// forward sends a plain HTTP proxy request upstream and copies the response back.
+// Requests that carry an injected credential are sent over TLS, so a secret is never
+// put on the wire in cleartext even when the agent asked for an http:// URL.
+func (p *Proxy) forward(w http.ResponseWriter, r *http.Request, rt *Runtime, decision Decision) {
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	removeHopHeaders(out.Header)
+
+	transport := p.transport()
+	if decision.Rule != nil {
+		switch decision.Mode {
+		case sidecarconfig.ModeVault:
+			if err := injectVaultCredential(rt.Vault, out, decision.Rule); err != nil {
+				p.Log.Error(err, "Failed to fetch credential", "host", r.URL.Host)
+				writeProxyError(w, http.StatusBadGateway, "credential_unavailable", r.URL.Host, decision.Mode, err.Error())
+				return
+			}
+			out.URL.Scheme = "https"
+		case sidecarconfig.ModeSecret:
+			if err := injectSecretFile(out, decision.Rule); err != nil {
+				p.Log.Error(err, "Failed to read credential", "host", r.URL.Host)
+				writeProxyError(w, http.StatusBadGateway, "credential_unavailable", r.URL.Host, decision.Mode, err.Error())
+				return
+			}
+			out.URL.Scheme = "https"
+		case sidecarconfig.ModeMTLS:
+			mtls, err := p.mtlsTransportFor(decision.Rule)
+			if err != nil {
+				p.Log.Error(err, "Failed to load mTLS credentials", "host", r.URL.Host)
+				writeProxyError(w, http.StatusBadGateway, "credential_unavailable", r.URL.Host, decision.Mode, err.Error())
+				return
+			}
+			out.URL.Scheme = "https"
+			transport = mtls
+		case sidecarconfig.ModeExchange:
+			if err := injectExchangedToken(rt.Exchanger, out, decision.Rule); err != nil {
+				p.Log.Error(err, "Token exchange failed", "host", r.URL.Host)
+				writeProxyError(w, exchangeErrorStatus(err), "token_exchange_failed", r.URL.Host, decision.Mode, err.Error())
+				return
+			}
+			out.URL.Scheme = "https"
+		}
+	}
+
+	resp, err := transport.RoundTrip(out)
+	if err != nil {
+		p.Log.Error(err, "Upstream request failed", "host", r.URL.Host)
+		http.Error(w, "upstream request failed", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	removeHopHeaders(resp.Header)
+	for k, vv := range resp.Header {
+		for _, v := range vv {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
+// tunnel opens a TCP connection to the CONNECT target and splices it with the client.
+// established is called once the client has been told the tunnel is open.
+func (p *Proxy) tunnel(w http.ResponseWriter, r *http.Request, established func()) {
+	upstream, err := p.dial(r.Context(), "tcp", r.Host)
+	if err != nil {
+		p.Log.Error(err, "Failed to open tunnel", "host", r.Host)
+		http.Error(w, "failed to reach upstream", http.StatusBadGateway)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "tunnelling not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buf, err := hijacker.Hijack()
+	if err != nil {
+		upstream.Close()
+		p.Log.Error(err, "Failed to hijack connection", "host", r.Host)
+		return
+	}
+
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		client.Close()
+		upstream.Close()
+		return
+	}
+	established()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// Flush anything the client sent after the CONNECT request line.
+		if n := buf.Reader.Buffered(); n > 0 {
+			pending, _ := buf.Reader.Peek(n)
+			_, _ = upstream.Write(pending)
+		}
+		_, _ = io.Copy(upstream, client)
+		closeWrite(upstream)
+	}()
+	_, _ = io.Copy(client, upstream)
+	closeWrite(client)
+	<-done
+	client.Close()
+	upstream.Close()
+}
+
+// injectVaultCredential sets the rule's header to the secret read from Vault.
+func injectVaultCredential(vault *VaultClient, r *http.Request, rule *sidecarconfig.ExternalRule) error {
+	if vault == nil {
+		return fmt.Errorf("vault is not configured for this sidecar")
+	}
+	value, err := vault.Secret(r.Context(), rule.VaultPath)
+	if err != nil {
+		return err
+	}
+	setCredential(r, rule, value)
+	return nil
+}
+
+// injectSecretFile sets the rule's header to the credential mounted from a Kubernetes
+// Secret. The file is read on every request so that Secret rotations, which kubelet
+// propagates to the mount, take effect without a restart.
+func injectSecretFile(r *http.Request, rule *sidecarconfig.ExternalRule) error {
+	data, err := os.ReadFile(rule.SecretFile)
+	if err != nil {
+		return fmt.Errorf("failed to read credential: %w", err)
+	}
+	value := strings.TrimRight(string(data), "\r\n")
+	if value == "" {
+		return fmt.Errorf("credential file %s is empty", rule.SecretFile)
+	}
+	setCredential(r, rule, value)
+	return nil
+}
+
+// injectExchangedToken exchanges the agent's bearer token, or its workload token when
+// the request carries none, and sets the rule's header to the result.
+func injectExchangedToken(exchanger *TokenExchanger, r *http.Request, rule *sidecarconfig.ExternalRule) error {
+	if exchanger == nil {
+		return &ExchangeError{Err: fmt.Errorf("token exchange is not configured for this sidecar")}
+	}
+	token, err := exchanger.Exchange(r.Context(), bearerToken(r.Header), rule)
+	if err != nil {
+		return err
+	}
+	setCredential(r, rule, token)
+	return nil
+}
+
+// exchangeErrorStatus answers 403 when the authorization server refused the exchange
+// and 502 when it could not be completed.
+func exchangeErrorStatus(err error) int {
+	var xerr *ExchangeError
+	if errors.As(err, &xerr) && xerr.Rejected() {
+		return http.StatusForbidden
+	}
+	return http.StatusBadGateway
+}
+
+// setCredential sets the rule's header to value. The agent's own Authorization header
+// is removed first: it often carries a user's token, which must not reach a third party
+// when the rule injects its credential into a different header.
+func setCredential(r *http.Request, rule *sidecarconfig.ExternalRule, value string) {
+	r.Header.Del("Authorization")
+	r.Header.Set(credentialHeader(rule), rule.HeaderPrefix+value)
+}
+
+// credentialHeader returns the header a rule injects its credential into.
+func credentialHeader(rule *sidecarconfig.ExternalRule) string {
+	if rule.Header != "" {
+		return rule.Header
+	}
+	return "Authorization"
+}
+
+func (p *Proxy) transport() http.RoundTripper {
+	if p.Transport != nil {
+		return p.Transport
+	}
+	return http.DefaultTransport
+}
+
+func (p *Proxy) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if p.DialContext != nil {
+		return p.DialContext(ctx, network, addr)
+	}
+	d := &net.Dialer{Timeout: 10 * time.Second}
+	return d.DialContext(ctx, network, addr)
+}
+
+// writeDenial writes the structured 403 response for a request refused by policy.
+func writeDenial(w http.ResponseWriter, host string, decision Decision) {
+	writeProxyError(w, http.StatusForbidden, "egress_denied", host, decision.Mode, decision.Reason)
+}
+
+// writeProxyError writes a structured JSON error response.
+func writeProxyError(w http.ResponseWriter, status int, code, host, mode, reason string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(proxyError{
+		Error:  code,
+		Host:   sidecarconfig.NormalizeHost(host),
+		Mode:   mode,
+		Reason: reason,
+	})
+}
+
+// removeHopHeaders deletes hop-by-hop headers, including any listed in Connection.
+func removeHopHeaders(h http.Header) {
+	for _, f := range h.Values("Connection") {
+		for _, name := range strings.Split(f, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// closeWrite half-closes a connection when supported so the peer sees EOF, and fully
+// closes it otherwise.
+func closeWrite(c net.Conn) {
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		_ = cw.CloseWrite()
+		return
+	}
+	_ = c.Close()
+}
@@ -0,0 +1,328 @@
+package sidecar
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+
+	"github.com/agentoperations/agent-access-control/pkg/sidecarconfig"
+)
+
+const testConfig = `
+gateway:
+  host: agent-gateway.default.svc.cluster.local
+  mode: passthrough
+allowedAgents:
+  - weather-agent
+external:
+  defaultMode: deny
+  rules:
+    - host: 127.0.0.1
+      mode: passthrough
+    - host: blocked.example.com
+      mode: deny
+    - host: api.example.com
+      mode: vault
+      vaultPath: secret/data/api-key
+      header: Authorization
+      headerPrefix: "Bearer "
+    - host: 127.0.0.1
+      paths: [/admin]
+      mode: deny
+    - host: readonly.example.com
+      mode: passthrough
+      allowedMethods: [GET, HEAD]
+      allowedPaths: [/repos/*/issues, /user]
+`
+
+func testProxyConfig(t *testing.T) *sidecarconfig.Config {
+	t.Helper()
+	cfg, err := sidecarconfig.Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return cfg
+}
+
+func TestConfigDecide(t *testing.T) {
+	cfg := testProxyConfig(t)
+
+	tests := []struct {
+		host    string
+		path    string
+		allowed bool
+		mode    string
+	}{
+		{"127.0.0.1:8080", "/", true, sidecarconfig.ModePassthrough},
+		{"127.0.0.1:8080", "/admin/users", false, sidecarconfig.ModeDeny},
+		{"127.0.0.1:8080", "/public/../admin", false, sidecarconfig.ModeDeny},
+		{"127.0.0.1:8080", "/administrator", true, sidecarconfig.ModePassthrough},
+		{"127.0.0.1:8080", "", true, sidecarconfig.ModePassthrough},
+		{"API.example.com:443", "/", true, sidecarconfig.ModeVault},
+		{"blocked.example.com", "/", false, sidecarconfig.ModeDeny},
+		{"unknown.example.com", "/", false, sidecarconfig.ModeDeny},
+		{"agent-gateway.default.svc.cluster.local:80", "/", true, sidecarconfig.ModePassthrough},
+		{"readonly.example.com", "/user/repos", true, sidecarconfig.ModePassthrough},
+		{"readonly.example.com", "/repos/org/issues/1", true, sidecarconfig.ModePassthrough},
+		{"readonly.example.com", "/repos/org/pulls", false, sidecarconfig.ModePassthrough},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host+tt.path, func(t *testing.T) {
+			target := sidecarconfig.NewTarget(tt.host, 443, tt.path)
+			target.Method = http.MethodGet
+			d := Decide(cfg, target)
+			if d.Allowed != tt.allowed || d.Mode != tt.mode {
+				t.Errorf("expected allowed=%v mode=%s, got allowed=%v mode=%s (%s)", tt.allowed, tt.mode, d.Allowed, d.Mode, d.Reason)
+			}
+		})
+	}
+
+	cfg.External.DefaultMode = sidecarconfig.ModePassthrough
+	if d := Decide(cfg, sidecarconfig.NewTarget("unknown.example.com", 443, "/")); !d.Allowed || d.Rule != nil {
+		t.Errorf("expected passthrough default to allow unmatched host, got %+v", d)
+	}
+}
+
+func newTestProxy(t *testing.T) *httptest.Server {
+	t.Helper()
+	proxy := httptest.NewServer(&Proxy{Config: testProxyConfig(t), Log: logr.Discard()})
+	t.Cleanup(proxy.Close)
+	return proxy
+}
+
+func proxyClient(t *testing.T, proxy *httptest.Server) *http.Client {
+	t.Helper()
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatalf("failed to parse proxy URL: %v", err)
+	}
+	return &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, //nolint:gosec // test upstream certificate
+	}}
+}
+
+// upstreamTransport reaches TLS test upstreams through the proxy. Tests address them as
+// localhost, which the httptest certificate does not name.
+func upstreamTransport() http.RoundTripper {
+	return &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}} //nolint:gosec // test upstream certificate
+}
+
+func TestProxy_ForwardsAllowedHost(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Connection") != "" {
+			t.Errorf("expected hop-by-hop headers to be removed")
+		}
+		fmt.Fprint(w, "hello from upstream")
+	}))
+	defer upstream.Close()
+
+	resp, err := proxyClient(t, newTestProxy(t)).Get(upstream.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "hello from upstream" {
+		t.Errorf("expected upstream response, got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestProxy_TunnelsAllowedHTTPSHost(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "secure hello")
+	}))
+	defer upstream.Close()
+
+	resp, err := proxyClient(t, newTestProxy(t)).Get(upstream.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "secure hello" {
+		t.Errorf("expected tunnelled upstream response, got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestProxy_DeniesHost(t *testing.T) {
+	proxy := newTestProxy(t)
+
+	t.Run("http", func(t *testing.T) {
+		resp, err := proxyClient(t, proxy).Get("http://blocked.example.com/repos")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("expected 403, got %d", resp.StatusCode)
+		}
+		var body proxyError
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("expected JSON denial body: %v", err)
+		}
+		if body.Error != "egress_denied" || body.Host != "blocked.example.com" || body.Mode != sidecarconfig.ModeDeny {
+			t.Errorf("unexpected denial body %+v", body)
+		}
+	})
+
+	t.Run("path", func(t *testing.T) {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf("expected request to %s to be denied", r.URL.Path)
+		}))
+		defer upstream.Close()
+
+		resp, err := proxyClient(t, proxy).Get(upstream.URL + "/admin/users")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("expected 403 for denied path, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("method", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodDelete, "http://readonly.example.com/user/repos", nil)
+		resp, err := proxyClient(t, proxy).Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var body proxyError
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("expected JSON denial body: %v", err)
+		}
+		if resp.StatusCode != http.StatusForbidden || !strings.Contains(body.Reason, "method DELETE is not allowed") {
+			t.Errorf("expected 403 with method reason, got %d %+v", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("connect_to_restricted_rule", func(t *testing.T) {
+		conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to dial proxy: %v", err)
+		}
+		defer conn.Close()
+
+		fmt.Fprint(conn, "CONNECT readonly.example.com:443 HTTP/1.1\r\nHost: readonly.example.com:443\r\n\r\n")
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatalf("failed to read CONNECT response: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("expected 403 for CONNECT to a rule with allowlists, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("connect", func(t *testing.T) {
+		conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to dial proxy: %v", err)
+		}
+		defer conn.Close()
+
+		fmt.Fprint(conn, "CONNECT unknown.example.com:443 HTTP/1.1\r\nHost: unknown.example.com:443\r\n\r\n")
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatalf("failed to read CONNECT response: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("expected 403 for CONNECT to unmatched host, got %d", resp.StatusCode)
+		}
+	})
+}
+
+func TestProxy_InjectsSecretCredential(t *testing.T) {
+	var gotAuth string
+	record := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+	})
+	upstream := httptest.NewTLSServer(record)
+	defer upstream.Close()
+
+	secretFile := filepath.Join(t.TempDir(), "api-key")
+	if err := os.WriteFile(secretFile, []byte("sk-first\n"), 0o600); err != nil {
+		t.Fatalf("failed to write secret: %v", err)
+	}
+
+	cfg := testProxyConfig(t)
+	cfg.External.Rules = []sidecarconfig.ExternalRule{{
+		Host:         "127.0.0.1",
+		Mode:         sidecarconfig.ModeSecret,
+		SecretFile:   secretFile,
+		HeaderPrefix: "Bearer ",
+	}}
+	proxy := httptest.NewServer(&Proxy{Config: cfg, Transport: upstreamTransport(), Log: logr.Discard()})
+	defer proxy.Close()
+	client := proxyClient(t, proxy)
+
+	// The agent asks for http://; the proxy originates TLS before injecting.
+	upstreamURL := "http://" + upstream.Listener.Addr().String()
+	get := func() int {
+		t.Helper()
+		resp, err := client.Get(upstreamURL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := get(); status != http.StatusOK || gotAuth != "Bearer sk-first" {
+		t.Fatalf("expected injected credential, got %d %q", status, gotAuth)
+	}
+
+	if err := os.WriteFile(secretFile, []byte("sk-rotated"), 0o600); err != nil {
+		t.Fatalf("failed to rotate secret: %v", err)
+	}
+	if status := get(); status != http.StatusOK || gotAuth != "Bearer sk-rotated" {
+		t.Errorf("expected rotated credential, got %d %q", status, gotAuth)
+	}
+
+	if err := os.Remove(secretFile); err != nil {
+		t.Fatalf("failed to remove secret: %v", err)
+	}
+	if status := get(); status != http.StatusBadGateway {
+		t.Errorf("expected 502 when the credential is missing, got %d", status)
+	}
+
+	t.Run("cleartext upstream never receives the credential", func(t *testing.T) {
+		if err := os.WriteFile(secretFile, []byte("sk-first"), 0o600); err != nil {
+			t.Fatalf("failed to write secret: %v", err)
+		}
+		plain := httptest.NewServer(record)
+		defer plain.Close()
+		gotAuth = ""
+
+		resp, err := client.Get(plain.URL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadGateway {
+			t.Errorf("expected 502 for an upstream that does not speak TLS, got %d", resp.StatusCode)
+		}
+		if gotAuth != "" {
+			t.Errorf("expected no credential over cleartext HTTP, upstream got %q", gotAuth)
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package sidecar
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/agentoperations/agent-access-control/pkg/sidecarconfig"
+)
+
+// limiterKey identifies the token bucket of a rate-limited rule by its match criteria
+// and limit, so a reload that leaves the rule unchanged keeps the bucket.
+func limiterKey(rule *sidecarconfig.ExternalRule) string {
+	rl := rule.RateLimit
+	return fmt.Sprintf("%s|%d/%s|%d", describeRule(rule), rl.Requests, limiterWindow(rl), rl.BurstSize())
+}
+
+// limiterWindow returns the window of rl, falling back to the default for one that
+// does not parse.
+func limiterWindow(rl *sidecarconfig.RateLimit) time.Duration {
+	window, err := rl.WindowDuration()
+	if err != nil {
+		// Validate rejects such configs before they are applied.
+		return sidecarconfig.DefaultRateLimitWindow
+	}
+	return window
+}
+
+// newLimiters builds the token buckets for the rate-limited rules in cfg. Buckets from
+// prev are carried over for rules whose match criteria and limit are unchanged; those
+// of rules no longer in cfg are dropped with prev.
+func newLimiters(cfg *sidecarconfig.Config, prev *Runtime) map[string]*rate.Limiter {
+	limiters := map[string]*rate.Limiter{}
+	if cfg == nil {
+		return limiters
+	}
+	for i := range cfg.External.Rules {
+		rule := &cfg.External.Rules[i]
+		if rule.RateLimit == nil {
+			continue
+		}
+		key := limiterKey(rule)
+		if _, ok := limiters[key]; ok {
+			continue
+		}
+		if prev != nil {
+			if l, ok := prev.limiters[key]; ok {
+				limiters[key] = l
+				continue
+			}
+		}
+		rl := rule.RateLimit
+		limiters[key] = rate.NewLimiter(rate.Every(limiterWindow(rl)/time.Duration(rl.Requests)), rl.BurstSize())
+	}
+	return limiters
+}
+
+// takeToken consumes a token for a request matching rule, one of rt's rules. When the
+// bucket is empty it returns false and how long until a token is available.
+func (rt *Runtime) takeToken(rule *sidecarconfig.ExternalRule) (bool, time.Duration) {
+	limiter, ok := rt.limiters[limiterKey(rule)]
+	if !ok {
+		return true, 0
+	}
+	reservation := limiter.Reserve()
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		return false, delay
+	}
+	return true, 0
+}
+
+// retryAfterSeconds formats a delay for the Retry-After header, rounding up.
+func retryAfterSeconds(delay time.Duration) string {
+	return fmt.Sprintf("%d", int(math.Ceil(delay.Seconds())))
+}
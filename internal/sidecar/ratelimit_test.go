@@ -0,0 +1,115 @@
+package sidecar
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/agentoperations/agent-access-control/pkg/sidecarconfig"
+)
+
+func TestProxy_RateLimitsRule(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	cfg := testProxyConfig(t)
+	cfg.External.Rules[0].RateLimit = &sidecarconfig.RateLimit{Requests: 2, Window: "1h"}
+	metrics := NewMetrics(prometheus.NewRegistry())
+	p := &Proxy{Config: cfg, Log: logr.Discard(), Metrics: metrics}
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+	client := proxyClient(t, proxy)
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(upstream.URL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: expected 200 within the burst, got %d", i, resp.StatusCode)
+		}
+	}
+
+	resp, err := client.Get(upstream.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 once the burst is spent, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("expected Retry-After header")
+	}
+	var body proxyError
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("expected JSON error body: %v", err)
+	}
+	if body.Error != "rate_limited" {
+		t.Errorf("expected rate_limited error, got %+v", body)
+	}
+
+	if got := testutil.ToFloat64(metrics.denied.WithLabelValues(deniedByRateLimit, sidecarconfig.ModePassthrough, "127.0.0.1")); got != 1 {
+		t.Errorf("expected 1 rate limited request, got %v", got)
+	}
+
+	t.Run("limiter survives reload of an unchanged rule", func(t *testing.T) {
+		reloaded := testProxyConfig(t)
+		reloaded.External.Rules[0].RateLimit = &sidecarconfig.RateLimit{Requests: 2, Window: "1h"}
+		p.Apply(&Runtime{Config: reloaded})
+
+		resp, err := client.Get(upstream.URL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusTooManyRequests {
+			t.Errorf("expected bucket to stay empty after reload, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("buckets of removed rules are dropped on reload", func(t *testing.T) {
+		prev := p.current()
+		reloaded := testProxyConfig(t)
+		reloaded.External.Rules[0].RateLimit = &sidecarconfig.RateLimit{Requests: 5, Window: "1h"}
+		rt, err := NewRuntime(reloaded, "changed", prev, RuntimeOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(rt.limiters) != 1 {
+			t.Fatalf("expected only the changed rule's bucket, got %d", len(rt.limiters))
+		}
+		for key := range prev.limiters {
+			if _, ok := rt.limiters[key]; ok {
+				t.Errorf("expected bucket %q of the replaced limit to be dropped", key)
+			}
+		}
+		p.Apply(rt)
+
+		resp, err := client.Get(upstream.URL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("expected a fresh bucket for the changed limit, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("policy denials are counted", func(t *testing.T) {
+		resp, err := client.Get("http://blocked.example.com/")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		if got := testutil.ToFloat64(metrics.denied.WithLabelValues(deniedByPolicy, sidecarconfig.ModeDeny, "blocked.example.com")); got != 1 {
+			t.Errorf("expected 1 policy denial, got %v", got)
+		}
+	})
+}
@@ -0,0 +1,64 @@
+package sidecar
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/agentoperations/agent-access-control/pkg/sidecarconfig"
+)
+
+// systemBundles are the CA bundle locations of common Linux distributions, as searched
+// by crypto/x509.
+var systemBundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/pki/tls/cacert.pem",
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+// WriteTrustBundle writes the system CA bundle followed by the interception CA
+// certificate to path. Agent containers point their TLS clients at the result, so they
+// trust the certificates the proxy mints for intercepted hosts as well as the real
+// certificates of tunnelled ones. The CA key is never copied.
+func WriteTrustBundle(cfg *sidecarconfig.Config, path string) error {
+	if cfg.TLSInterception == nil {
+		return fmt.Errorf("sidecar config does not enable tlsInterception")
+	}
+	caPEM, err := os.ReadFile(cfg.TLSInterception.CACertFile)
+	if err != nil {
+		return fmt.Errorf("failed to read interception CA: %w", err)
+	}
+	block, _ := pem.Decode(caPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return fmt.Errorf("interception CA %s contains no certificate", cfg.TLSInterception.CACertFile)
+	}
+	if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+		return fmt.Errorf("failed to parse interception CA: %w", err)
+	}
+
+	var bundle bytes.Buffer
+	for _, f := range systemBundles {
+		if data, err := os.ReadFile(f); err == nil {
+			bundle.Write(bytes.TrimRight(data, "\n"))
+			bundle.WriteByte('\n')
+			break
+		}
+	}
+	if err := pem.Encode(&bundle, block); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create trust bundle directory: %w", err)
+	}
+	if err := os.WriteFile(path, bundle.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write trust bundle: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,355 @@
+package sidecar
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/agentoperations/agent-access-control/pkg/sidecarconfig"
+)
+
+const (
+	// DefaultVaultAuthMount is the mount path of Vault's Kubernetes auth method.
+	DefaultVaultAuthMount = "kubernetes"
+
+	// DefaultServiceAccountTokenPath is where the pod's ServiceAccount token is projected.
+	DefaultServiceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	// DefaultVaultCacheTTL is how long a KV v2 secret is served from cache.
+	DefaultVaultCacheTTL = 5 * time.Minute
+
+	// vaultRenewWindow is how long before expiry the Vault token is renewed.
+	vaultRenewWindow = 30 * time.Second
+
+	// vaultRequestTimeout bounds a shared secret fetch, which outlives the request that
+	// started it when that request is cancelled.
+	vaultRequestTimeout = 30 * time.Second
+
+	maxVaultResponseBytes = 1 << 20
+)
+
+// VaultClient reads secrets from a Vault-compatible KV v2 engine, authenticating with
+// the Kubernetes auth method using the pod's ServiceAccount token. Both the Vault
+// token and the secrets read with it are cached in memory.
+type VaultClient struct {
+	// Address is the Vault server URL, e.g. https://vault.vault.svc:8200.
+	Address string
+
+	// Role is the Vault Kubernetes auth role to log in as.
+	Role string
+
+	// AuthMount is the mount path of the Kubernetes auth method. Defaults to "kubernetes".
+	AuthMount string
+
+	// TokenPath is the ServiceAccount token file. Defaults to the in-cluster projected token.
+	TokenPath string
+
+	// CacheTTL is how long secrets are cached. Defaults to five minutes.
+	CacheTTL time.Duration
+
+	// HTTPClient is used to talk to Vault. http.DefaultClient is used when nil.
+	HTTPClient *http.Client
+
+	// now is overridden in tests.
+	now func() time.Time
+
+	fetches singleflight.Group
+	logins  singleflight.Group
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+	renewable   bool
+	secrets     map[string]cachedSecret
+}
+
+type cachedSecret struct {
+	value   string
+	expires time.Time
+}
+
+// vaultResponse is the envelope shared by Vault API responses.
+type vaultResponse struct {
+	LeaseDuration int             `json:"lease_duration"`
+	Data          json.RawMessage `json:"data"`
+	Auth          *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// NewVaultClient returns a client for the vault block of the sidecar config, trusting
+// the configured CA bundle when one is set.
+func NewVaultClient(cfg *sidecarconfig.Vault) (*VaultClient, error) {
+	v := &VaultClient{
+		Address:   cfg.Address,
+		Role:      cfg.Role,
+		AuthMount: cfg.AuthMount,
+	}
+	if cfg.CACertFile != "" {
+		pem, err := os.ReadFile(cfg.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read vault CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("vault CA bundle %s contains no certificates", cfg.CACertFile)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+		v.HTTPClient = &http.Client{Transport: transport, Timeout: 30 * time.Second}
+	}
+	return v, nil
+}
+
+// Secret returns the value stored at a KV v2 path. The path may name a field with a
+// "#field" suffix (e.g. "secret/data/github#token"); without one, the secret must hold
+// exactly one field. Concurrent reads of the same uncached path share one fetch, and
+// no lock is held while Vault is being called.
+func (v *VaultClient) Secret(ctx context.Context, path string) (string, error) {
+	path, field, _ := strings.Cut(path, "#")
+	cacheKey := path + "#" + field
+
+	v.mu.Lock()
+	cached, ok := v.secrets[cacheKey]
+	v.mu.Unlock()
+	if ok && v.clock().Before(cached.expires) {
+		return cached.value, nil
+	}
+
+	ch := v.fetches.DoChan(cacheKey, func() (interface{}, error) {
+		fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), vaultRequestTimeout)
+		defer cancel()
+		return v.fetch(fetchCtx, path, field, cacheKey)
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return "", res.Err
+		}
+		return res.Val.(string), nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// fetch reads a secret from Vault and caches it under cacheKey.
+func (v *VaultClient) fetch(ctx context.Context, path, field, cacheKey string) (string, error) {
+	token, err := v.ensureToken(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := v.do(ctx, http.MethodGet, "/v1/"+strings.TrimPrefix(path, "/"), token, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to read vault secret %s: %w", path, err)
+	}
+
+	var kv struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Data, &kv); err != nil {
+		return "", fmt.Errorf("failed to decode vault secret %s: %w", path, err)
+	}
+
+	value, err := secretField(kv.Data, field)
+	if err != nil {
+		return "", fmt.Errorf("vault secret %s: %w", path, err)
+	}
+
+	ttl := v.cacheTTL()
+	if resp.LeaseDuration > 0 && time.Duration(resp.LeaseDuration)*time.Second < ttl {
+		ttl = time.Duration(resp.LeaseDuration) * time.Second
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.secrets == nil {
+		v.secrets = map[string]cachedSecret{}
+	}
+	v.secrets[cacheKey] = cachedSecret{value: value, expires: v.clock().Add(ttl)}
+	return value, nil
+}
+
+// ensureToken returns a valid Vault token, renewing it shortly before its lease ends
+// and logging in again when renewal is not possible. Concurrent callers share one
+// renewal or login.
+func (v *VaultClient) ensureToken(ctx context.Context) (string, error) {
+	if token, ok := v.currentToken(); ok {
+		return token, nil
+	}
+	token, err, _ := v.logins.Do("token", func() (interface{}, error) {
+		if token, ok := v.currentToken(); ok {
+			return token, nil
+		}
+
+		v.mu.Lock()
+		token, renewable, expiry := v.token, v.renewable, v.tokenExpiry
+		v.mu.Unlock()
+		if token != "" && renewable && v.clock().Before(expiry) {
+			if resp, err := v.renew(ctx, token); err == nil {
+				return v.setToken(resp)
+			}
+		}
+
+		resp, err := v.login(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return v.setToken(resp)
+	})
+	if err != nil {
+		return "", err
+	}
+	return token.(string), nil
+}
+
+// currentToken returns the cached Vault token if it is not due for renewal.
+func (v *VaultClient) currentToken() (string, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.token != "" && (v.tokenExpiry.IsZero() || v.clock().Before(v.tokenExpiry.Add(-vaultRenewWindow))) {
+		return v.token, true
+	}
+	return "", false
+}
+
+// login authenticates with the Kubernetes auth method.
+func (v *VaultClient) login(ctx context.Context) (*vaultResponse, error) {
+	jwt, err := os.ReadFile(v.tokenPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ServiceAccount token: %w", err)
+	}
+
+	mount := v.AuthMount
+	if mount == "" {
+		mount = DefaultVaultAuthMount
+	}
+	resp, err := v.do(ctx, http.MethodPost, "/v1/auth/"+strings.Trim(mount, "/")+"/login", "", map[string]string{
+		"role": v.Role,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("vault kubernetes login failed: %w", err)
+	}
+	return resp, nil
+}
+
+// renew extends the lease of token.
+func (v *VaultClient) renew(ctx context.Context, token string) (*vaultResponse, error) {
+	resp, err := v.do(ctx, http.MethodPost, "/v1/auth/token/renew-self", token, map[string]string{})
+	if err != nil {
+		return nil, fmt.Errorf("vault token renewal failed: %w", err)
+	}
+	return resp, nil
+}
+
+// setToken stores the token from a login or renewal response and returns it.
+func (v *VaultClient) setToken(resp *vaultResponse) (string, error) {
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return "", fmt.Errorf("vault response carried no client token")
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.token = resp.Auth.ClientToken
+	v.renewable = resp.Auth.Renewable
+	v.tokenExpiry = time.Time{}
+	if resp.Auth.LeaseDuration > 0 {
+		v.tokenExpiry = v.clock().Add(time.Duration(resp.Auth.LeaseDuration) * time.Second)
+	}
+	return v.token, nil
+}
+
+// do sends a request to the Vault API and decodes the response envelope.
+func (v *VaultClient) do(ctx context.Context, method, path, token string, body interface{}) (*vaultResponse, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(v.Address, "/")+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+
+	httpClient := v.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out vaultResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxVaultResponseBytes)).Decode(&out); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to decode vault response (status %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault returned status %d: %s", resp.StatusCode, strings.Join(out.Errors, "; "))
+	}
+	return &out, nil
+}
+
+func (v *VaultClient) tokenPath() string {
+	if v.TokenPath != "" {
+		return v.TokenPath
+	}
+	return DefaultServiceAccountTokenPath
+}
+
+func (v *VaultClient) cacheTTL() time.Duration {
+	if v.CacheTTL > 0 {
+		return v.CacheTTL
+	}
+	return DefaultVaultCacheTTL
+}
+
+func (v *VaultClient) clock() time.Time {
+	if v.now != nil {
+		return v.now()
+	}
+	return time.Now()
+}
+
+// secretField picks the named field from KV v2 secret data, or its only field when
+// no name is given.
+func secretField(data map[string]interface{}, field string) (string, error) {
+	if field == "" {
+		if len(data) != 1 {
+			return "", fmt.Errorf("secret has %d fields; select one with a #field suffix", len(data))
+		}
+		for _, value := range data {
+			return fmt.Sprint(value), nil
+		}
+	}
+
+	value, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("field %q not found", field)
+	}
+	return fmt.Sprint(value), nil
+}
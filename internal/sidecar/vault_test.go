@@ -0,0 +1,341 @@
+package sidecar
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/agentoperations/agent-access-control/pkg/sidecarconfig"
+)
+
+// fakeVault is a minimal stand-in for the Vault Kubernetes auth and KV v2 APIs.
+type fakeVault struct {
+	t *testing.T
+
+	mu        sync.Mutex
+	logins    int
+	renewals  int
+	reads     int
+	leaseSecs int
+	secrets   map[string]map[string]interface{}
+}
+
+func newFakeVault(t *testing.T) (*fakeVault, *httptest.Server) {
+	t.Helper()
+	fv := &fakeVault{
+		t:         t,
+		leaseSecs: 3600,
+		secrets: map[string]map[string]interface{}{
+			"secret/data/api-key": {"token": "s3cr3t"},
+			"secret/data/multi":   {"user": "bob", "password": "hunter2"},
+		},
+	}
+	srv := httptest.NewServer(fv)
+	t.Cleanup(srv.Close)
+	return fv, srv
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/auth/kubernetes/login":
+		var body struct {
+			Role string `json:"role"`
+			JWT  string `json:"jwt"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body.Role != "weather-agent" || body.JWT != "sa-token" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errors":["permission denied"]}`)
+			return
+		}
+		f.logins++
+		f.writeAuth(w, fmt.Sprintf("login-%d", f.logins))
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/auth/token/renew-self":
+		f.renewals++
+		f.writeAuth(w, r.Header.Get("X-Vault-Token"))
+	case r.Method == http.MethodGet:
+		if r.Header.Get("X-Vault-Token") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errors":["missing client token"]}`)
+			return
+		}
+		data, ok := f.secrets[r.URL.Path[len("/v1/"):]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errors":[]}`)
+			return
+		}
+		f.reads++
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{"data": data, "metadata": map[string]interface{}{"version": 1}},
+		})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeVault) writeAuth(w http.ResponseWriter, token string) {
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"auth": map[string]interface{}{
+			"client_token":   token,
+			"lease_duration": f.leaseSecs,
+			"renewable":      true,
+		},
+	})
+}
+
+func newTestVaultClient(t *testing.T, addr string, now *time.Time) *VaultClient {
+	t.Helper()
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenPath, []byte("sa-token\n"), 0o600); err != nil {
+		t.Fatalf("failed to write token: %v", err)
+	}
+	return &VaultClient{
+		Address:   addr,
+		Role:      "weather-agent",
+		TokenPath: tokenPath,
+		CacheTTL:  time.Minute,
+		now:       func() time.Time { return *now },
+	}
+}
+
+func TestVaultClient_Secret(t *testing.T) {
+	fv, srv := newFakeVault(t)
+	now := time.Now()
+	vc := newTestVaultClient(t, srv.URL, &now)
+	ctx := context.Background()
+
+	value, err := vc.Secret(ctx, "secret/data/api-key")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != "s3cr3t" {
+		t.Errorf("expected s3cr3t, got %q", value)
+	}
+
+	if _, err := vc.Secret(ctx, "secret/data/api-key"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fv.logins != 1 || fv.reads != 1 {
+		t.Errorf("expected cached secret (1 login, 1 read), got %d logins, %d reads", fv.logins, fv.reads)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := vc.Secret(ctx, "secret/data/api-key"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fv.reads != 2 {
+		t.Errorf("expected secret to be re-read after cache TTL, got %d reads", fv.reads)
+	}
+
+	value, err = vc.Secret(ctx, "secret/data/multi#password")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != "hunter2" {
+		t.Errorf("expected hunter2, got %q", value)
+	}
+
+	if _, err := vc.Secret(ctx, "secret/data/multi"); err == nil {
+		t.Error("expected error for multi-field secret without #field")
+	}
+	if _, err := vc.Secret(ctx, "secret/data/missing"); err == nil {
+		t.Error("expected error for missing secret")
+	}
+}
+
+func TestVaultClient_ConcurrentReads(t *testing.T) {
+	fv, _ := newFakeVault(t)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/secret/data/multi" {
+			<-release
+		}
+		fv.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	now := time.Now()
+	vc := newTestVaultClient(t, srv.URL, &now)
+	ctx := context.Background()
+
+	if _, err := vc.Secret(ctx, "secret/data/api-key"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := vc.Secret(ctx, "secret/data/multi#user"); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+
+	// While Vault is stalled on one path, cached secrets are still served.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := vc.Secret(ctx, "secret/data/api-key"); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a cached secret not to wait for a slow Vault read")
+	}
+
+	close(release)
+	wg.Wait()
+	if fv.reads != 2 {
+		t.Errorf("expected concurrent reads of one path to share a fetch, got %d reads", fv.reads)
+	}
+}
+
+func TestVaultClient_RenewsAndRelogs(t *testing.T) {
+	fv, srv := newFakeVault(t)
+	fv.leaseSecs = 120
+	now := time.Now()
+	vc := newTestVaultClient(t, srv.URL, &now)
+	vc.CacheTTL = time.Second
+	ctx := context.Background()
+
+	if _, err := vc.Secret(ctx, "secret/data/api-key"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Inside the renewal window: the lease is renewed rather than logging in again.
+	now = now.Add(100 * time.Second)
+	if _, err := vc.Secret(ctx, "secret/data/api-key"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fv.logins != 1 || fv.renewals != 1 {
+		t.Errorf("expected 1 login and 1 renewal, got %d logins, %d renewals", fv.logins, fv.renewals)
+	}
+
+	// After the token expired: a fresh login is required.
+	now = now.Add(time.Hour)
+	if _, err := vc.Secret(ctx, "secret/data/api-key"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fv.logins != 2 {
+		t.Errorf("expected re-login after expiry, got %d logins", fv.logins)
+	}
+}
+
+func TestNewVaultClient_TrustsCABundle(t *testing.T) {
+	fv := &fakeVault{t: t, leaseSecs: 3600, secrets: map[string]map[string]interface{}{"secret/data/api-key": {"token": "s3cr3t"}}}
+	srv := httptest.NewTLSServer(fv)
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, certPEM, 0o600); err != nil {
+		t.Fatalf("failed to write CA: %v", err)
+	}
+
+	cfg, err := sidecarconfig.Parse([]byte(fmt.Sprintf("vault:\n  address: %s\n  role: weather-agent\n  caCertFile: %s\n", srv.URL, caFile)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	vc, err := NewVaultClient(cfg.Vault)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now := time.Now()
+	vc.TokenPath = newTestVaultClient(t, srv.URL, &now).TokenPath
+
+	value, err := vc.Secret(context.Background(), "secret/data/api-key")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != "s3cr3t" {
+		t.Errorf("expected s3cr3t, got %q", value)
+	}
+
+	if _, err := NewVaultClient(&sidecarconfig.Vault{Address: srv.URL, CACertFile: filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Error("expected error for missing CA bundle")
+	}
+}
+
+func TestVaultClient_LoginFailure(t *testing.T) {
+	_, srv := newFakeVault(t)
+	now := time.Now()
+	vc := newTestVaultClient(t, srv.URL, &now)
+	vc.Role = "unknown"
+
+	if _, err := vc.Secret(context.Background(), "secret/data/api-key"); err == nil {
+		t.Error("expected login failure to be returned")
+	}
+}
+
+func TestProxy_InjectsVaultCredential(t *testing.T) {
+	_, vaultSrv := newFakeVault(t)
+	now := time.Now()
+
+	var gotAuth string
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+	}))
+	defer upstream.Close()
+
+	cfg := testProxyConfig(t)
+	// Route api.example.com to the local upstream.
+	cfg.External.Rules[2].Host = "localhost"
+	proxy := httptest.NewServer(&Proxy{
+		Config:    cfg,
+		Vault:     newTestVaultClient(t, vaultSrv.URL, &now),
+		Transport: upstreamTransport(),
+		Log:       logr.Discard(),
+	})
+	defer proxy.Close()
+
+	// The agent asks for http://; the proxy must only send the credential over TLS.
+	upstreamURL := "http://localhost:" + upstream.URL[len("https://127.0.0.1:"):]
+	resp, err := proxyClient(t, proxy).Get(upstreamURL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if gotAuth != "Bearer s3cr3t" {
+		t.Errorf("expected injected credential, got %q", gotAuth)
+	}
+
+	t.Run("vault unavailable", func(t *testing.T) {
+		proxy := httptest.NewServer(&Proxy{Config: cfg, Log: logr.Discard()})
+		defer proxy.Close()
+
+		resp, err := proxyClient(t, proxy).Get(upstreamURL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var body proxyError
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("expected JSON error body: %v", err)
+		}
+		if resp.StatusCode != http.StatusBadGateway || body.Error != "credential_unavailable" {
+			t.Errorf("expected 502 credential_unavailable, got %d %+v", resp.StatusCode, body)
+		}
+	})
+}
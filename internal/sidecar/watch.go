@@ -0,0 +1,247 @@
+package sidecar
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+
+	"github.com/agentoperations/agent-access-control/pkg/sidecarconfig"
+)
+
+// defaultPollInterval is how often the config file is re-read in case a filesystem
+// event was missed.
+const defaultPollInterval = 30 * time.Second
+
+// RuntimeOptions are the settings that come from the sidecar command line rather than
+// config.yaml and apply to every loaded config.
+type RuntimeOptions struct {
+	// TokenPath is the ServiceAccount token presented to Vault and exchanged when a
+	// request carries no bearer token.
+	TokenPath string
+
+	// VaultCacheTTL is how long secrets read from Vault are cached.
+	VaultCacheTTL time.Duration
+}
+
+// NewRuntime builds the proxy runtime for cfg. Credential clients and the JWKS verifier
+// from prev are reused when their config blocks are unchanged, so cached tokens, secrets
+// and signing keys survive reloads, as do the rate limit buckets of unchanged rules.
+func NewRuntime(cfg *sidecarconfig.Config, hash string, prev *Runtime, opts RuntimeOptions) (*Runtime, error) {
+	rt := &Runtime{Config: cfg, Hash: hash, limiters: newLimiters(cfg, prev)}
+
+	if cfg.Vault != nil {
+		if prev != nil && prev.Vault != nil && reflect.DeepEqual(prev.Config.Vault, cfg.Vault) {
+			rt.Vault = prev.Vault
+		} else {
+			vault, err := NewVaultClient(cfg.Vault)
+			if err != nil {
+				return nil, err
+			}
+			vault.TokenPath = opts.TokenPath
+			vault.CacheTTL = opts.VaultCacheTTL
+			rt.Vault = vault
+		}
+	}
+
+	if cfg.IdentityProvider != nil {
+		if prev != nil && prev.Exchanger != nil && reflect.DeepEqual(prev.Config.IdentityProvider, cfg.IdentityProvider) {
+			rt.Exchanger = prev.Exchanger
+		} else {
+			exchanger, err := NewTokenExchanger(cfg.IdentityProvider)
+			if err != nil {
+				return nil, err
+			}
+			exchanger.SubjectTokenPath = opts.TokenPath
+			rt.Exchanger = exchanger
+		}
+	}
+
+	if cfg.TLSInterception != nil {
+		if prev != nil && prev.Interceptor != nil && reflect.DeepEqual(prev.Config.TLSInterception, cfg.TLSInterception) {
+			rt.Interceptor = prev.Interceptor
+		} else {
+			ca, err := NewCertAuthority(cfg.TLSInterception)
+			if err != nil {
+				return nil, err
+			}
+			rt.Interceptor = ca
+		}
+	}
+
+	if cfg.Inbound != nil {
+		if prev != nil && prev.Verifier != nil && prev.Config.Inbound != nil &&
+			prev.Config.Inbound.Issuer == cfg.Inbound.Issuer && prev.Config.Inbound.JWKSURL == cfg.Inbound.JWKSURL {
+			rt.Verifier = prev.Verifier
+		} else {
+			rt.Verifier = NewJWKSVerifier(cfg.Inbound)
+		}
+	}
+
+	return rt, nil
+}
+
+// ConfigWatcher keeps a Proxy in sync with the config.yaml mounted from the sidecar
+// ConfigMap. A changed file is parsed, validated and swapped in atomically; a file that
+// fails to load leaves the previous config in force.
+type ConfigWatcher struct {
+	// Path is the config.yaml to watch.
+	Path string
+
+	// Proxy receives each successfully loaded runtime.
+	Proxy *Proxy
+
+	// Inbound, if set, also receives each successfully loaded runtime.
+	Inbound *InboundProxy
+
+	// Options are applied to every runtime built from the config.
+	Options RuntimeOptions
+
+	// PollInterval is how often the file is re-read regardless of filesystem events.
+	// Defaults to 30 seconds.
+	PollInterval time.Duration
+
+	// Log receives reload diagnostics.
+	Log logr.Logger
+
+	mu        sync.Mutex
+	runtime   *Runtime
+	status    ConfigStatus
+	lastError string
+}
+
+// ConfigStatus is served by the status endpoint.
+type ConfigStatus struct {
+	// ConfigHash is the SHA-256 of the config.yaml currently enforced.
+	ConfigHash string `json:"configHash"`
+
+	// LoadedAt is when the enforced config was loaded.
+	LoadedAt time.Time `json:"loadedAt"`
+
+	// Rules is the number of external rules in the enforced config.
+	Rules int `json:"rules"`
+
+	// LastError describes the most recent failed reload, if the file currently on disk
+	// was rejected.
+	LastError string `json:"lastError,omitempty"`
+}
+
+// Reload reads the config file and applies it if its content changed. On error the
+// previously applied config stays in force.
+func (w *ConfigWatcher) Reload() error {
+	data, err := os.ReadFile(w.Path)
+	if err != nil {
+		return w.reloadFailed(fmt.Errorf("failed to read sidecar config: %w", err))
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.runtime != nil && w.runtime.Hash == hash {
+		w.lastError = ""
+		return nil
+	}
+
+	cfg, err := sidecarconfig.Parse(data)
+	if err != nil {
+		return w.reloadFailedLocked(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return w.reloadFailedLocked(fmt.Errorf("invalid sidecar config: %w", err))
+	}
+	rt, err := NewRuntime(cfg, hash, w.runtime, w.Options)
+	if err != nil {
+		return w.reloadFailedLocked(err)
+	}
+
+	w.Proxy.Apply(rt)
+	if w.Inbound != nil {
+		w.Inbound.Apply(rt)
+	}
+	w.runtime = rt
+	w.lastError = ""
+	w.status = ConfigStatus{ConfigHash: hash, LoadedAt: time.Now().UTC(), Rules: len(cfg.External.Rules)}
+	w.Log.Info("Loaded sidecar config", "hash", hash, "rules", len(cfg.External.Rules),
+		"defaultMode", cfg.External.DefaultMode)
+	return nil
+}
+
+func (w *ConfigWatcher) reloadFailed(err error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.reloadFailedLocked(err)
+}
+
+func (w *ConfigWatcher) reloadFailedLocked(err error) error {
+	if err.Error() != w.lastError {
+		w.Log.Error(err, "Keeping previous sidecar config", "hash", w.status.ConfigHash)
+	}
+	w.lastError = err.Error()
+	return err
+}
+
+// Start watches the directory holding the config file until ctx is cancelled. The
+// directory is watched rather than the file because kubelet updates ConfigMap volumes
+// by swapping a symlink.
+func (w *ConfigWatcher) Start(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(filepath.Dir(w.Path)); err != nil {
+		return fmt.Errorf("failed to watch %s: %w", filepath.Dir(w.Path), err)
+	}
+
+	interval := w.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-watcher.Events:
+			_ = w.Reload()
+		case err := <-watcher.Errors:
+			w.Log.Error(err, "File watcher error")
+		case <-ticker.C:
+			_ = w.Reload()
+		}
+	}
+}
+
+// Status returns the state of the enforced config.
+func (w *ConfigWatcher) Status() ConfigStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	status := w.status
+	status.LastError = w.lastError
+	return status
+}
+
+// ServeHTTP serves the config status as JSON.
+func (w *ConfigWatcher) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(w.Status())
+}
@@ -0,0 +1,182 @@
+package sidecar
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/agentoperations/agent-access-control/pkg/sidecarconfig"
+)
+
+func writeConfig(t *testing.T, path, data string) string {
+	t.Helper()
+	// Write and rename so the watcher never observes a partially written file.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(data), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatalf("failed to rename config: %v", err)
+	}
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func newTestWatcher(t *testing.T) (*ConfigWatcher, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	return &ConfigWatcher{Path: path, Proxy: &Proxy{Log: logr.Discard()}, Log: logr.Discard()}, path
+}
+
+func TestConfigWatcher_Reload(t *testing.T) {
+	w, path := newTestWatcher(t)
+
+	firstHash := writeConfig(t, path, testConfig)
+	if err := w.Reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status := w.Status(); status.ConfigHash != firstHash || status.Rules != 5 {
+		t.Errorf("unexpected status after first load %+v", status)
+	}
+	if d := Decide(w.Proxy.current().Config, sidecarconfig.Target{Host: "blocked.example.com"}); d.Allowed {
+		t.Fatal("expected blocked.example.com to be denied by the initial config")
+	}
+
+	secondHash := writeConfig(t, path, strings.Replace(testConfig, "host: blocked.example.com\n      mode: deny", "host: blocked.example.com\n      mode: passthrough", 1))
+	if err := w.Reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d := Decide(w.Proxy.current().Config, sidecarconfig.Target{Host: "blocked.example.com"}); !d.Allowed {
+		t.Error("expected reloaded config to allow blocked.example.com")
+	}
+	if status := w.Status(); status.ConfigHash != secondHash {
+		t.Errorf("expected hash %s, got %s", secondHash, status.ConfigHash)
+	}
+
+	t.Run("keeps previous config on parse error", func(t *testing.T) {
+		writeConfig(t, path, "external: [not, a, map")
+		if err := w.Reload(); err == nil {
+			t.Fatal("expected parse error")
+		}
+		status := w.Status()
+		if status.ConfigHash != secondHash || status.LastError == "" {
+			t.Errorf("expected previous hash and a lastError, got %+v", status)
+		}
+		if d := Decide(w.Proxy.current().Config, sidecarconfig.Target{Host: "blocked.example.com"}); !d.Allowed {
+			t.Error("expected previous config to stay in force")
+		}
+	})
+
+	t.Run("keeps previous config on validation error", func(t *testing.T) {
+		writeConfig(t, path, "external:\n  defaultMode: deny\n  rules:\n    - host: api.example.com\n      mode: vault\n")
+		if err := w.Reload(); err == nil || !strings.Contains(err.Error(), "vaultPath") {
+			t.Fatalf("expected vaultPath validation error, got %v", err)
+		}
+		if status := w.Status(); status.ConfigHash != secondHash {
+			t.Errorf("expected previous hash to be kept, got %s", status.ConfigHash)
+		}
+	})
+
+	t.Run("clears error once the file is fixed", func(t *testing.T) {
+		writeConfig(t, path, testConfig)
+		if err := w.Reload(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if status := w.Status(); status.ConfigHash != firstHash || status.LastError != "" {
+			t.Errorf("unexpected status %+v", status)
+		}
+	})
+}
+
+func TestConfigWatcher_StartPicksUpChanges(t *testing.T) {
+	w, path := newTestWatcher(t)
+	w.PollInterval = time.Hour // rely on filesystem events
+
+	writeConfig(t, path, testConfig)
+	if err := w.Reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- w.Start(ctx) }()
+
+	// Give the watcher a moment to register before changing the file.
+	time.Sleep(100 * time.Millisecond)
+	want := writeConfig(t, path, strings.Replace(testConfig, "defaultMode: deny", "defaultMode: passthrough", 1))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for w.Status().ConfigHash != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("watcher did not reload config; status %+v", w.Status())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if d := Decide(w.Proxy.current().Config, sidecarconfig.Target{Host: "unknown.example.com"}); !d.Allowed {
+		t.Error("expected new default mode to be enforced")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error from Start: %v", err)
+	}
+}
+
+func TestNewRuntime_ReusesUnchangedClients(t *testing.T) {
+	cfg := &sidecarconfig.Config{Vault: &sidecarconfig.Vault{Address: "https://vault.example.com", Role: "agent"}, IdentityProvider: &sidecarconfig.IdentityProvider{TokenEndpoint: "https://idp.example.com/token"}}
+	first, err := NewRuntime(cfg, "a", nil, RuntimeOptions{TokenPath: "/token"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first.Vault.TokenPath != "/token" || first.Exchanger.SubjectTokenPath != "/token" {
+		t.Errorf("expected runtime options to be applied, got %+v %+v", first.Vault, first.Exchanger)
+	}
+
+	same := &sidecarconfig.Config{Vault: &sidecarconfig.Vault{Address: "https://vault.example.com", Role: "agent"}, IdentityProvider: &sidecarconfig.IdentityProvider{TokenEndpoint: "https://idp.example.com/token"}}
+	second, err := NewRuntime(same, "b", first, RuntimeOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if second.Vault != first.Vault || second.Exchanger != first.Exchanger {
+		t.Error("expected unchanged credential clients to be reused")
+	}
+
+	changed := &sidecarconfig.Config{Vault: &sidecarconfig.Vault{Address: "https://vault.example.com", Role: "other"}}
+	third, err := NewRuntime(changed, "c", second, RuntimeOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if third.Vault == second.Vault || third.Exchanger != nil {
+		t.Error("expected a new vault client and no exchanger")
+	}
+}
+
+func TestConfigWatcher_StatusEndpoint(t *testing.T) {
+	w, path := newTestWatcher(t)
+	hash := writeConfig(t, path, testConfig)
+	if err := w.Reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	w.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	var status ConfigStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("expected JSON status: %v", err)
+	}
+	if status.ConfigHash != hash || status.LoadedAt.IsZero() {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
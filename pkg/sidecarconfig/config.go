@@ -0,0 +1,409 @@
+// Package sidecarconfig defines the configuration the controller writes into the
+// sidecar-config-{card} ConfigMap and that sidecar proxies enforce. It is the single
+// source of the schema for the controller, the reference sidecar, and any third-party
+// proxy implementation.
+package sidecarconfig
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// APIVersion is the schema version written by this package.
+const APIVersion = "sidecar.kagenti.com/v1alpha1"
+
+// Credential handling modes.
+const (
+	ModeVault       = "vault"
+	ModeExchange    = "exchange"
+	ModeSecret      = "secret"
+	ModeMTLS        = "mtls"
+	ModePassthrough = "passthrough"
+	ModeDeny        = "deny"
+)
+
+// ServiceAccountPrefix starts the sub of every Kubernetes ServiceAccount token,
+// system:serviceaccount:{namespace}:{name}.
+const ServiceAccountPrefix = "system:serviceaccount:"
+
+// Config is the complete sidecar configuration, serialized as config.yaml.
+type Config struct {
+	// APIVersion is the schema version. Configs written before the field existed are
+	// read as the current version.
+	APIVersion string `json:"apiVersion"`
+
+	// Agent is the AgentCard the configuration was generated for. Proxies use it to
+	// attribute audit records.
+	Agent string `json:"agent,omitempty"`
+
+	// Gateway is the in-cluster agent gateway, which is always reachable.
+	Gateway Gateway `json:"gateway"`
+
+	// AllowedAgents are the agents this agent may call through the gateway.
+	AllowedAgents []string `json:"allowedAgents"`
+
+	// External holds the per-host egress rules.
+	External External `json:"external"`
+
+	// IdentityProvider is the token endpoint used by exchange rules.
+	IdentityProvider *IdentityProvider `json:"identityProvider,omitempty"`
+
+	// Vault is the Vault server used by vault rules.
+	Vault *Vault `json:"vault,omitempty"`
+
+	// TLSInterception lets the proxy terminate HTTPS tunnels to hosts whose rules need
+	// to see the request. Nil means every allowed tunnel is passed through opaquely.
+	TLSInterception *TLSInterception `json:"tlsInterception,omitempty"`
+
+	// Inbound enables JWT validation of requests to the agent. Nil means the sidecar
+	// does not front the agent port.
+	Inbound *Inbound `json:"inbound,omitempty"`
+}
+
+// Gateway describes the in-cluster agent gateway.
+type Gateway struct {
+	Host string `json:"host"`
+	Mode string `json:"mode"`
+}
+
+// External holds the per-host egress rules and the mode applied when no rule matches.
+type External struct {
+	Rules       []ExternalRule `json:"rules"`
+	DefaultMode string         `json:"defaultMode"`
+}
+
+// ExternalRule is the credential handling policy for a single external host, optionally
+// narrowed to some ports and path prefixes. See RuleFor for how overlapping rules are
+// resolved.
+type ExternalRule struct {
+	Host  string   `json:"host"`
+	Ports []int    `json:"ports,omitempty"`
+	Paths []string `json:"paths,omitempty"`
+	Mode  string   `json:"mode"`
+
+	// AllowedMethods and AllowedPaths restrict what may be requested from the host.
+	// Empty lists allow everything. See Permits.
+	AllowedMethods []string `json:"allowedMethods,omitempty"`
+	AllowedPaths   []string `json:"allowedPaths,omitempty"`
+
+	VaultPath string `json:"vaultPath,omitempty"`
+
+	// SecretFile is where the credential for a secret rule is mounted in the sidecar.
+	SecretFile string `json:"secretFile,omitempty"`
+
+	// ClientCertFile and ClientKeyFile are the PEM client certificate and key an mtls
+	// rule presents. CACertFile, if set, replaces the system roots for verifying the host.
+	ClientCertFile string `json:"clientCertFile,omitempty"`
+	ClientKeyFile  string `json:"clientKeyFile,omitempty"`
+	CACertFile     string `json:"caCertFile,omitempty"`
+
+	Audience     string   `json:"audience,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+	Header       string   `json:"header,omitempty"`
+	HeaderPrefix string   `json:"headerPrefix,omitempty"`
+
+	// RateLimit caps requests matching the rule. Nil means unlimited.
+	RateLimit *RateLimit `json:"rateLimit,omitempty"`
+}
+
+// DefaultRateLimitWindow is the rate limit window used when none is set.
+const DefaultRateLimitWindow = time.Minute
+
+// RateLimit is a token-bucket limit on the requests matching a rule.
+type RateLimit struct {
+	// Requests is the number of requests allowed per window.
+	Requests int `json:"requests"`
+
+	// Window is a Go duration such as "1m" or "1h". Defaults to one minute.
+	Window string `json:"window,omitempty"`
+
+	// Burst is the bucket size. Defaults to Requests.
+	Burst int `json:"burst,omitempty"`
+}
+
+// WindowDuration returns the parsed window, or DefaultRateLimitWindow when unset.
+func (l *RateLimit) WindowDuration() (time.Duration, error) {
+	if l.Window == "" {
+		return DefaultRateLimitWindow, nil
+	}
+	d, err := time.ParseDuration(l.Window)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("window must be positive, got %s", l.Window)
+	}
+	return d, nil
+}
+
+// BurstSize returns the bucket size, defaulting to Requests.
+func (l *RateLimit) BurstSize() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Requests
+}
+
+// IdentityProvider is the OAuth token endpoint used by exchange rules. Secret values are
+// referenced by the path they are mounted at, never inlined.
+type IdentityProvider struct {
+	TokenEndpoint    string `json:"tokenEndpoint"`
+	ClientID         string `json:"clientId,omitempty"`
+	ClientSecretFile string `json:"clientSecretFile,omitempty"`
+}
+
+// Vault is the Vault server used by vault rules.
+type Vault struct {
+	Address    string `json:"address"`
+	Role       string `json:"role"`
+	AuthMount  string `json:"authMount,omitempty"`
+	CACertFile string `json:"caCertFile,omitempty"`
+}
+
+// TLSInterception is the certificate authority the proxy mints per-host certificates
+// from when it intercepts a tunnel. See Config.Intercepts.
+type TLSInterception struct {
+	CACertFile string `json:"caCertFile"`
+	CAKeyFile  string `json:"caKeyFile"`
+}
+
+// Inbound configures the reverse proxy in front of the agent. A request is forwarded
+// only if its bearer token is signed by a key in the issuer's JWKS, has not expired,
+// names Issuer as iss, includes one of Audiences in aud, and either has a sub listed in
+// AllowedSubjects or a UserClaims claim matching one of AllowedUsers.
+type Inbound struct {
+	// UpstreamPort is the port the agent listens on, on the loopback interface.
+	UpstreamPort int `json:"upstreamPort"`
+
+	Issuer string `json:"issuer"`
+
+	// JWKSURL is where signing keys are fetched. When empty it is discovered from the
+	// issuer's /.well-known/openid-configuration.
+	JWKSURL string `json:"jwksUrl,omitempty"`
+
+	Audiences []string `json:"audiences"`
+
+	// AllowedSubjects are the accepted sub values, such as
+	// system:serviceaccount:{namespace}:{name}. A trailing "*" accepts any subject with
+	// that prefix, so "*" accepts any subject.
+	AllowedSubjects []string `json:"allowedSubjects"`
+
+	// AllowedUsers are matched against the UserClaims of the token, the way the gateway
+	// matches the AgentPolicy's allowedUsers. "*" accepts any caller whose sub is not a
+	// ServiceAccount, a trailing "*" matches by prefix, and a list claim matches if any
+	// of its values does.
+	AllowedUsers []string `json:"allowedUsers,omitempty"`
+
+	// UserClaims are the claims AllowedUsers are matched against, with dots for nested
+	// claims. Defaults to ["sub"].
+	UserClaims []string `json:"userClaims,omitempty"`
+}
+
+// Load reads and parses the configuration file at path.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sidecar config: %w", err)
+	}
+	return Parse(data)
+}
+
+// Parse parses a YAML configuration. Unknown fields are ignored so that proxies keep
+// working when a newer controller adds optional fields; an unknown apiVersion is not.
+func Parse(data []byte) (*Config, error) {
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse sidecar config: %w", err)
+	}
+	if cfg.APIVersion == "" {
+		cfg.APIVersion = APIVersion
+	}
+	if cfg.APIVersion != APIVersion {
+		return nil, fmt.Errorf("unsupported sidecar config apiVersion %q (want %q)", cfg.APIVersion, APIVersion)
+	}
+	return &cfg, nil
+}
+
+// Validate checks that the configuration can be enforced: every rule names a valid host
+// pattern and a known mode, no two rules cover the same host, port and path,
+// mode-specific fields are present, and an inbound block names an issuer and audience.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.APIVersion != "" && c.APIVersion != APIVersion {
+		errs = append(errs, fmt.Errorf("apiVersion: unsupported version %q", c.APIVersion))
+	}
+	if c.External.DefaultMode != "" && !KnownMode(c.External.DefaultMode) {
+		errs = append(errs, fmt.Errorf("external.defaultMode: unknown mode %q", c.External.DefaultMode))
+	}
+
+	seen := map[string]int{}
+	for i, rule := range c.External.Rules {
+		field := fmt.Sprintf("external.rules[%d]", i)
+		if err := validateHostPattern(rule.Host); err != nil {
+			errs = append(errs, fmt.Errorf("%s.host: %w", field, err))
+		}
+		for _, port := range rule.Ports {
+			if port < 1 || port > 65535 {
+				errs = append(errs, fmt.Errorf("%s.ports: invalid port %d", field, port))
+			}
+		}
+		for _, p := range rule.Paths {
+			if !strings.HasPrefix(p, "/") {
+				errs = append(errs, fmt.Errorf("%s.paths: %q must start with /", field, p))
+			}
+		}
+		for _, method := range rule.AllowedMethods {
+			if method == "" || strings.ContainsAny(method, " \t/") {
+				errs = append(errs, fmt.Errorf("%s.allowedMethods: invalid method %q", field, method))
+			}
+		}
+		for _, p := range rule.AllowedPaths {
+			if !strings.HasPrefix(p, "/") {
+				errs = append(errs, fmt.Errorf("%s.allowedPaths: %q must start with /", field, p))
+			} else if _, err := path.Match(p, ""); err != nil {
+				errs = append(errs, fmt.Errorf("%s.allowedPaths: invalid glob %q: %w", field, p, err))
+			}
+		}
+		if rl := rule.RateLimit; rl != nil {
+			if rl.Requests < 1 {
+				errs = append(errs, fmt.Errorf("%s.rateLimit.requests: must be at least 1", field))
+			}
+			if rl.Burst < 0 {
+				errs = append(errs, fmt.Errorf("%s.rateLimit.burst: must not be negative", field))
+			}
+			if _, err := rl.WindowDuration(); err != nil {
+				errs = append(errs, fmt.Errorf("%s.rateLimit.window: %w", field, err))
+			}
+		}
+		// Two rules that match exactly the same requests would make precedence depend on
+		// their order, so each host, port and path combination may appear only once.
+		for _, key := range ruleKeys(rule) {
+			if j, ok := seen[key]; ok && j != i {
+				errs = append(errs, fmt.Errorf("%s: overlaps external.rules[%d]", field, j))
+				break
+			}
+			seen[key] = i
+		}
+
+		switch rule.Mode {
+		case ModeVault:
+			if rule.VaultPath == "" {
+				errs = append(errs, fmt.Errorf("%s.vaultPath: required for mode vault", field))
+			}
+		case ModeSecret:
+			if rule.SecretFile == "" {
+				errs = append(errs, fmt.Errorf("%s.secretFile: required for mode secret", field))
+			}
+		case ModeMTLS:
+			if rule.ClientCertFile == "" || rule.ClientKeyFile == "" {
+				errs = append(errs, fmt.Errorf("%s.clientCertFile: client certificate and key required for mode mtls", field))
+			}
+		case ModeExchange, ModePassthrough, ModeDeny:
+		default:
+			errs = append(errs, fmt.Errorf("%s.mode: unknown mode %q", field, rule.Mode))
+		}
+	}
+
+	if c.IdentityProvider != nil && c.IdentityProvider.TokenEndpoint == "" {
+		errs = append(errs, errors.New("identityProvider.tokenEndpoint: required"))
+	}
+	if c.Vault != nil {
+		if c.Vault.Address == "" {
+			errs = append(errs, errors.New("vault.address: required"))
+		}
+		if c.Vault.Role == "" {
+			errs = append(errs, errors.New("vault.role: required"))
+		}
+	}
+	if ti := c.TLSInterception; ti != nil && (ti.CACertFile == "" || ti.CAKeyFile == "") {
+		errs = append(errs, errors.New("tlsInterception.caCertFile: CA certificate and key required"))
+	}
+	if in := c.Inbound; in != nil {
+		if in.UpstreamPort < 1 || in.UpstreamPort > 65535 {
+			errs = append(errs, fmt.Errorf("inbound.upstreamPort: invalid port %d", in.UpstreamPort))
+		}
+		if err := validateHTTPURL(in.Issuer); err != nil {
+			errs = append(errs, fmt.Errorf("inbound.issuer: %w", err))
+		}
+		if in.JWKSURL != "" {
+			if err := validateHTTPURL(in.JWKSURL); err != nil {
+				errs = append(errs, fmt.Errorf("inbound.jwksUrl: %w", err))
+			}
+		}
+		if len(in.Audiences) == 0 {
+			errs = append(errs, errors.New("inbound.audiences: at least one audience is required"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateHostPattern checks that a rule host is a hostname, optionally prefixed with
+// "*." to match subdomains, and carries no port.
+func validateHostPattern(host string) error {
+	if host == "" {
+		return errors.New("required")
+	}
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return fmt.Errorf("%q must not include a port; use ports", host)
+	}
+	name, _ := strings.CutPrefix(host, "*.")
+	if name == "" || strings.Contains(name, "*") {
+		return fmt.Errorf("%q: wildcards are only allowed as a leading \"*.\"", host)
+	}
+	return nil
+}
+
+// validateHTTPURL checks that s is an absolute http or https URL.
+func validateHTTPURL(s string) error {
+	if s == "" {
+		return errors.New("required")
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q must be an absolute http or https URL", s)
+	}
+	return nil
+}
+
+// ruleKeys returns one key per host, port and path combination the rule covers.
+func ruleKeys(rule ExternalRule) []string {
+	ports := []string{"*"}
+	if len(rule.Ports) > 0 {
+		ports = ports[:0]
+		for _, port := range rule.Ports {
+			ports = append(ports, strconv.Itoa(port))
+		}
+	}
+	paths := rule.Paths
+	if len(paths) == 0 {
+		paths = []string{""}
+	}
+	var keys []string
+	for _, port := range ports {
+		for _, p := range paths {
+			keys = append(keys, NormalizeHost(rule.Host)+"|"+port+"|"+p)
+		}
+	}
+	return keys
+}
+
+// KnownMode reports whether mode is one of the credential handling modes.
+func KnownMode(mode string) bool {
+	switch mode {
+	case ModeVault, ModeExchange, ModeSecret, ModeMTLS, ModePassthrough, ModeDeny:
+		return true
+	}
+	return false
+}
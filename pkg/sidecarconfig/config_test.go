@@ -0,0 +1,409 @@
+package sidecarconfig
+
+import (
+	"strings"
+	"testing"
+)
+
+const testConfig = `
+apiVersion: sidecar.kagenti.com/v1alpha1
+gateway:
+  host: agent-gateway.default.svc.cluster.local
+  mode: passthrough
+allowedAgents:
+  - weather-agent
+external:
+  defaultMode: deny
+  rules:
+    - host: api.github.com
+      mode: exchange
+      audience: github-tools
+      scopes: [repo]
+    - host: api.example.com
+      mode: vault
+      vaultPath: secret/data/api-key
+      header: Authorization
+      headerPrefix: "Bearer "
+vault:
+  address: https://vault.vault.svc:8200
+  role: weather-agent
+`
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Gateway.Host != "agent-gateway.default.svc.cluster.local" {
+		t.Errorf("unexpected gateway host %q", cfg.Gateway.Host)
+	}
+	if len(cfg.External.Rules) != 2 || cfg.External.Rules[1].HeaderPrefix != "Bearer " {
+		t.Errorf("unexpected rules %+v", cfg.External.Rules)
+	}
+	if cfg.Vault == nil || cfg.Vault.Role != "weather-agent" {
+		t.Errorf("unexpected vault block %+v", cfg.Vault)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected valid config, got %v", err)
+	}
+
+	t.Run("missing apiVersion is read as current", func(t *testing.T) {
+		cfg, err := Parse([]byte("external:\n  defaultMode: deny\n"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.APIVersion != APIVersion {
+			t.Errorf("expected apiVersion %q, got %q", APIVersion, cfg.APIVersion)
+		}
+	})
+
+	t.Run("unknown fields are ignored", func(t *testing.T) {
+		if _, err := Parse([]byte("apiVersion: sidecar.kagenti.com/v1alpha1\nfutureField: true\n")); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("unsupported apiVersion", func(t *testing.T) {
+		if _, err := Parse([]byte("apiVersion: sidecar.kagenti.com/v2\n")); err == nil {
+			t.Error("expected error for unsupported apiVersion")
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr string
+	}{
+		{
+			name: "valid",
+			cfg: Config{External: External{DefaultMode: ModeDeny, Rules: []ExternalRule{
+				{Host: "api.example.com", Mode: ModeVault, VaultPath: "secret/data/x"},
+				{Host: "api.github.com", Mode: ModeExchange},
+			}}},
+		},
+		{
+			name:    "unknown default mode",
+			cfg:     Config{External: External{DefaultMode: "allow"}},
+			wantErr: "external.defaultMode",
+		},
+		{
+			name:    "missing host",
+			cfg:     Config{External: External{Rules: []ExternalRule{{Mode: ModeDeny}}}},
+			wantErr: "external.rules[0].host: required",
+		},
+		{
+			name:    "unknown rule mode",
+			cfg:     Config{External: External{Rules: []ExternalRule{{Host: "a.example.com", Mode: "open"}}}},
+			wantErr: "external.rules[0].mode",
+		},
+		{
+			name:    "vault without path",
+			cfg:     Config{External: External{Rules: []ExternalRule{{Host: "a.example.com", Mode: ModeVault}}}},
+			wantErr: "vaultPath: required",
+		},
+		{
+			name: "duplicate host",
+			cfg: Config{External: External{Rules: []ExternalRule{
+				{Host: "a.example.com", Mode: ModeDeny},
+				{Host: "A.example.com", Mode: ModePassthrough},
+			}}},
+			wantErr: "overlaps external.rules[0]",
+		},
+		{
+			name: "same host on different ports and paths",
+			cfg: Config{External: External{Rules: []ExternalRule{
+				{Host: "a.example.com", Mode: ModeDeny},
+				{Host: "a.example.com", Ports: []int{443}, Mode: ModePassthrough},
+				{Host: "a.example.com", Paths: []string{"/v1"}, Mode: ModePassthrough},
+			}}},
+		},
+		{
+			name: "overlapping port",
+			cfg: Config{External: External{Rules: []ExternalRule{
+				{Host: "a.example.com", Ports: []int{80, 443}, Mode: ModeDeny},
+				{Host: "a.example.com", Ports: []int{443}, Mode: ModePassthrough},
+			}}},
+			wantErr: "overlaps external.rules[0]",
+		},
+		{
+			name:    "port in host",
+			cfg:     Config{External: External{Rules: []ExternalRule{{Host: "a.example.com:443", Mode: ModeDeny}}}},
+			wantErr: "must not include a port",
+		},
+		{
+			name:    "wildcard in the middle",
+			cfg:     Config{External: External{Rules: []ExternalRule{{Host: "api.*.example.com", Mode: ModeDeny}}}},
+			wantErr: "wildcards are only allowed",
+		},
+		{
+			name:    "bare wildcard",
+			cfg:     Config{External: External{Rules: []ExternalRule{{Host: "*", Mode: ModeDeny}}}},
+			wantErr: "wildcards are only allowed",
+		},
+		{
+			name:    "invalid port",
+			cfg:     Config{External: External{Rules: []ExternalRule{{Host: "a.example.com", Ports: []int{70000}, Mode: ModeDeny}}}},
+			wantErr: "invalid port 70000",
+		},
+		{
+			name:    "secret without file",
+			cfg:     Config{External: External{Rules: []ExternalRule{{Host: "a.example.com", Mode: ModeSecret}}}},
+			wantErr: "secretFile: required",
+		},
+		{
+			name:    "mtls without key",
+			cfg:     Config{External: External{Rules: []ExternalRule{{Host: "a.example.com", Mode: ModeMTLS, ClientCertFile: "/tls.crt"}}}},
+			wantErr: "client certificate and key required",
+		},
+		{
+			name:    "invalid rate limit window",
+			cfg:     Config{External: External{Rules: []ExternalRule{{Host: "a.example.com", Mode: ModePassthrough, RateLimit: &RateLimit{Requests: 10, Window: "soon"}}}}},
+			wantErr: "rateLimit.window",
+		},
+		{
+			name:    "rate limit without requests",
+			cfg:     Config{External: External{Rules: []ExternalRule{{Host: "a.example.com", Mode: ModePassthrough, RateLimit: &RateLimit{}}}}},
+			wantErr: "rateLimit.requests",
+		},
+		{
+			name:    "invalid allowed path glob",
+			cfg:     Config{External: External{Rules: []ExternalRule{{Host: "a.example.com", AllowedPaths: []string{"/repos/[a"}, Mode: ModePassthrough}}}},
+			wantErr: "invalid glob",
+		},
+		{
+			name:    "relative path",
+			cfg:     Config{External: External{Rules: []ExternalRule{{Host: "a.example.com", Paths: []string{"v1"}, Mode: ModeDeny}}}},
+			wantErr: "must start with /",
+		},
+		{
+			name:    "incomplete vault block",
+			cfg:     Config{Vault: &Vault{Address: "https://vault"}},
+			wantErr: "vault.role: required",
+		},
+		{
+			name:    "incomplete identity provider",
+			cfg:     Config{IdentityProvider: &IdentityProvider{ClientID: "x"}},
+			wantErr: "identityProvider.tokenEndpoint: required",
+		},
+		{
+			name:    "tls interception without key",
+			cfg:     Config{TLSInterception: &TLSInterception{CACertFile: "/ca/tls.crt"}},
+			wantErr: "CA certificate and key required",
+		},
+		{
+			name:    "inbound issuer is not a URL",
+			cfg:     Config{Inbound: &Inbound{UpstreamPort: 8080, Issuer: "issuer.example.com", Audiences: []string{"weather-agent"}}},
+			wantErr: "inbound.issuer",
+		},
+		{
+			name:    "inbound without audience",
+			cfg:     Config{Inbound: &Inbound{UpstreamPort: 8080, Issuer: "https://issuer.example.com"}},
+			wantErr: "inbound.audiences",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestMatchHost(t *testing.T) {
+	tests := []struct {
+		pattern, host string
+		want          bool
+	}{
+		{"api.example.com", "api.example.com", true},
+		{"api.example.com", "API.Example.com:443", true},
+		{"api.example.com.", "api.example.com", true},
+		{"::1", "[::1]:8080", true},
+		{"api.example.com", "example.com", false},
+		{"", "api.example.com", false},
+	}
+	for _, tt := range tests {
+		if got := MatchHost(tt.pattern, tt.host); got != tt.want {
+			t.Errorf("MatchHost(%q, %q) = %v, want %v", tt.pattern, tt.host, got, tt.want)
+		}
+	}
+
+	cfg, err := Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rule := cfg.RuleFor(NewTarget("api.github.com:443", 443, "")); rule == nil || rule.Mode != ModeExchange {
+		t.Errorf("expected exchange rule for api.github.com, got %+v", rule)
+	}
+	if rule := cfg.RuleFor(NewTarget("unknown.example.com", 443, "")); rule != nil {
+		t.Errorf("expected no rule, got %+v", rule)
+	}
+	if !cfg.IsGateway("agent-gateway.default.svc.cluster.local:80") {
+		t.Error("expected gateway host to be recognized")
+	}
+}
+
+func TestMatchHost_Wildcard(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"storage.googleapis.com", true},
+		{"a.b.googleapis.com:443", true},
+		{"googleapis.com", false},
+		{"evilgoogleapis.com", false},
+		{"googleapis.com.evil.com", false},
+	}
+	for _, tt := range tests {
+		if got := MatchHost("*.googleapis.com", tt.host); got != tt.want {
+			t.Errorf("MatchHost(*.googleapis.com, %q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestNewTarget(t *testing.T) {
+	tests := []struct {
+		hostport, path string
+		want           Target
+	}{
+		{"API.Example.com", "/v1", Target{Host: "api.example.com", Port: 80, Path: "/v1"}},
+		{"api.example.com:8443", "", Target{Host: "api.example.com", Port: 8443}},
+		{"api.example.com", "/v1/../admin/", Target{Host: "api.example.com", Port: 80, Path: "/admin"}},
+		{"[::1]:9090", "models", Target{Host: "::1", Port: 9090, Path: "/models"}},
+	}
+	for _, tt := range tests {
+		if got := NewTarget(tt.hostport, 80, tt.path); got != tt.want {
+			t.Errorf("NewTarget(%q, 80, %q) = %+v, want %+v", tt.hostport, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRuleFor_Precedence(t *testing.T) {
+	cfg := &Config{External: External{DefaultMode: ModeDeny, Rules: []ExternalRule{
+		{Host: "*.googleapis.com", Mode: ModeExchange},
+		{Host: "*.storage.googleapis.com", Mode: ModeVault, VaultPath: "secret/data/gcs"},
+		{Host: "storage.googleapis.com", Mode: ModePassthrough},
+		{Host: "api.github.com", Mode: ModeDeny},
+		{Host: "api.github.com", Ports: []int{443}, Mode: ModeExchange},
+		{Host: "api.github.com", Ports: []int{443}, Paths: []string{"/repos"}, Mode: ModePassthrough},
+		{Host: "api.github.com", Ports: []int{443}, Paths: []string{"/repos/private"}, Mode: ModeDeny},
+		{Host: "*.github.com", Paths: []string{"/repos"}, Mode: ModeVault, VaultPath: "secret/data/gh"},
+	}}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected valid config, got %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		target   Target
+		wantMode string
+	}{
+		{"wildcard", NewTarget("pubsub.googleapis.com", 443, "/"), ModeExchange},
+		{"longer wildcard wins", NewTarget("eu.storage.googleapis.com", 443, "/"), ModeVault},
+		{"exact host beats wildcard", NewTarget("storage.googleapis.com", 443, "/"), ModePassthrough},
+		{"port rule beats portless rule", NewTarget("api.github.com", 443, "/user"), ModeExchange},
+		{"other port falls back to portless rule", NewTarget("api.github.com:8443", 443, "/user"), ModeDeny},
+		{"path prefix beats port-only rule", NewTarget("api.github.com", 443, "/repos/org/repo"), ModePassthrough},
+		{"longest path prefix wins", NewTarget("api.github.com", 443, "/repos/private/x"), ModeDeny},
+		{"tunnel ignores path rules", NewTarget("api.github.com", 443, ""), ModeExchange},
+		{"exact host beats wildcard with path", NewTarget("api.github.com", 443, "/repos"), ModePassthrough},
+		{"wildcard with path", NewTarget("uploads.github.com", 443, "/repos/x"), ModeVault},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := cfg.RuleFor(tt.target)
+			if rule == nil || rule.Mode != tt.wantMode {
+				t.Errorf("expected mode %s, got %+v", tt.wantMode, rule)
+			}
+		})
+	}
+	if rule := cfg.RuleFor(NewTarget("uploads.github.com", 443, "/user")); rule != nil {
+		t.Errorf("expected no rule outside the wildcard's paths, got %+v", rule)
+	}
+}
+
+func TestExternalRule_Permits(t *testing.T) {
+	rule := ExternalRule{
+		Host:           "api.github.com",
+		Mode:           ModeExchange,
+		AllowedMethods: []string{"GET", "HEAD"},
+		AllowedPaths:   []string{"/user", "/repos/*/*/issues"},
+	}
+
+	tests := []struct {
+		name, method, path string
+		want               bool
+		wantReason         string
+	}{
+		{"allowed prefix", "GET", "/user/repos", true, ""},
+		{"method case-insensitive", "head", "/user", true, ""},
+		{"glob with subpath", "GET", "/repos/org/repo/issues/7", true, ""},
+		{"glob segment mismatch", "GET", "/repos/org/issues", false, "path /repos/org/issues is not allowed"},
+		{"prefix is segment-aware", "GET", "/users", false, "path /users is not allowed"},
+		{"method denied", "DELETE", "/user", false, "method DELETE is not allowed"},
+		{"tunnel", "", "", false, "cannot be enforced on a tunnel"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := NewTarget("api.github.com", 443, tt.path)
+			target.Method = tt.method
+			ok, reason := rule.Permits(target)
+			if ok != tt.want || !strings.Contains(reason, tt.wantReason) {
+				t.Errorf("Permits() = %v %q, want %v containing %q", ok, reason, tt.want, tt.wantReason)
+			}
+		})
+	}
+
+	open := ExternalRule{Host: "api.github.com", Mode: ModePassthrough}
+	if ok, _ := open.Permits(Target{Host: "api.github.com"}); !ok {
+		t.Error("expected rule without allowlists to permit a tunnel")
+	}
+}
+
+func TestConfig_Intercepts(t *testing.T) {
+	cfg := Config{
+		Gateway:         Gateway{Host: "agent-gateway.default.svc.cluster.local"},
+		TLSInterception: &TLSInterception{CACertFile: "/ca/tls.crt", CAKeyFile: "/ca/tls.key"},
+		External: External{Rules: []ExternalRule{
+			{Host: "api.example.com", Mode: ModeVault, VaultPath: "secret/data/x"},
+			{Host: "open.example.com", Mode: ModePassthrough},
+			{Host: "readonly.example.com", Mode: ModePassthrough, AllowedMethods: []string{"GET"}},
+			{Host: "mixed.example.com", Mode: ModePassthrough},
+			{Host: "mixed.example.com", Paths: []string{"/admin"}, Mode: ModeDeny},
+			{Host: "*.partner.example.com", Ports: []int{8443}, Mode: ModeMTLS},
+		}},
+	}
+
+	tests := []struct {
+		hostport string
+		want     bool
+	}{
+		{"api.example.com:443", true},
+		{"open.example.com:443", false},
+		{"readonly.example.com:443", true},
+		{"mixed.example.com:443", true},
+		{"eu.partner.example.com:8443", true},
+		{"eu.partner.example.com:443", false},
+		{"unknown.example.com:443", false},
+		{"agent-gateway.default.svc.cluster.local:443", false},
+	}
+	for _, tt := range tests {
+		if got := cfg.Intercepts(NewTarget(tt.hostport, 443, "")); got != tt.want {
+			t.Errorf("Intercepts(%s) = %v, want %v", tt.hostport, got, tt.want)
+		}
+	}
+
+	cfg.TLSInterception = nil
+	if cfg.Intercepts(NewTarget("api.example.com:443", 443, "")) {
+		t.Error("expected no interception when tlsInterception is not configured")
+	}
+}
@@ -0,0 +1,212 @@
+package sidecarconfig
+
+import (
+	"net"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Target is the destination of an outbound request as seen by a proxy.
+type Target struct {
+	// Host is the normalized hostname, without a port.
+	Host string
+
+	// Port is the destination port. Zero means unknown, and rules that list ports
+	// do not match.
+	Port int
+
+	// Path is the cleaned request path. Empty means the proxy cannot see the path,
+	// as for a CONNECT tunnel, and rules that list paths do not match.
+	Path string
+
+	// Method is the HTTP method. Empty means the proxy cannot see the request, as for
+	// a CONNECT tunnel.
+	Method string
+}
+
+// NewTarget builds a Target from a host that may carry a port. defaultPort is used
+// when hostport has none; reqPath is cleaned so that dot segments cannot step outside
+// a path prefix.
+func NewTarget(hostport string, defaultPort int, reqPath string) Target {
+	t := Target{Host: NormalizeHost(hostport), Port: defaultPort}
+	if _, p, err := net.SplitHostPort(hostport); err == nil {
+		if port, err := strconv.Atoi(p); err == nil {
+			t.Port = port
+		}
+	}
+	if reqPath != "" {
+		t.Path = path.Clean("/" + reqPath)
+	}
+	return t
+}
+
+// MatchHost reports whether a rule host pattern matches the host of an outbound request.
+// The request host may carry a port; both are compared case-insensitively. A pattern of
+// the form "*.example.com" matches any subdomain of example.com, but not example.com.
+func MatchHost(pattern, host string) bool {
+	pattern = NormalizeHost(pattern)
+	host = NormalizeHost(host)
+	if pattern == "" {
+		return false
+	}
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return pattern == host
+}
+
+// matchPath reports whether reqPath falls under prefix. Prefixes match whole path
+// segments, so "/v1" matches "/v1" and "/v1/models" but not "/v1beta".
+func matchPath(prefix, reqPath string) bool {
+	if !strings.HasPrefix(reqPath, prefix) {
+		return false
+	}
+	return len(reqPath) == len(prefix) || strings.HasSuffix(prefix, "/") || reqPath[len(prefix)] == '/'
+}
+
+// Match reports whether the rule applies to t.
+func (r *ExternalRule) Match(t Target) bool {
+	_, ok := r.specificity(t)
+	return ok
+}
+
+// specificity scores how closely the rule matches t, as host, port and path
+// components compared in that order. ok is false when the rule does not match.
+func (r *ExternalRule) specificity(t Target) (score [3]int, ok bool) {
+	if !MatchHost(r.Host, t.Host) {
+		return score, false
+	}
+	if pattern := NormalizeHost(r.Host); strings.HasPrefix(pattern, "*.") {
+		score[0] = strings.Count(pattern, ".")
+	} else {
+		score[0] = len(pattern) + 1 // any exact host beats any wildcard
+	}
+
+	if len(r.Ports) > 0 {
+		if t.Port == 0 || !slices.Contains(r.Ports, t.Port) {
+			return score, false
+		}
+		score[1] = 1
+	}
+
+	if len(r.Paths) > 0 {
+		if t.Path == "" {
+			return score, false
+		}
+		matched := false
+		for _, prefix := range r.Paths {
+			if matchPath(prefix, t.Path) && len(prefix)+1 > score[2] {
+				score[2] = len(prefix) + 1
+				matched = true
+			}
+		}
+		if !matched {
+			return score, false
+		}
+	}
+	return score, true
+}
+
+// Permits reports whether the rule's allowedMethods and allowedPaths admit t. When they
+// do not, reason explains why. A target whose method or path is unknown is refused by a
+// rule that restricts them, since the restriction could not be enforced.
+func (r *ExternalRule) Permits(t Target) (ok bool, reason string) {
+	if len(r.AllowedMethods) > 0 {
+		if t.Method == "" {
+			return false, "rule for " + r.Host + " restricts methods, which cannot be enforced on a tunnel"
+		}
+		if !slices.ContainsFunc(r.AllowedMethods, func(m string) bool { return strings.EqualFold(m, t.Method) }) {
+			return false, "method " + t.Method + " is not allowed for " + r.Host + " (allowed: " + strings.Join(r.AllowedMethods, ", ") + ")"
+		}
+	}
+	if len(r.AllowedPaths) > 0 {
+		if t.Path == "" {
+			return false, "rule for " + r.Host + " restricts paths, which cannot be enforced on a tunnel"
+		}
+		if !slices.ContainsFunc(r.AllowedPaths, func(p string) bool { return matchAllowedPath(p, t.Path) }) {
+			return false, "path " + t.Path + " is not allowed for " + r.Host + " (allowed: " + strings.Join(r.AllowedPaths, ", ") + ")"
+		}
+	}
+	return true, ""
+}
+
+// matchAllowedPath reports whether reqPath falls under an allowedPaths entry. Entries
+// without glob characters are segment prefixes as in matchPath. A glob is matched with
+// path.Match against the leading segments of reqPath, so "/repos/*/issues" also admits
+// "/repos/org/issues/1".
+func matchAllowedPath(pattern, reqPath string) bool {
+	if !strings.ContainsAny(pattern, "*?[") {
+		return matchPath(pattern, reqPath)
+	}
+	n := strings.Count(strings.TrimSuffix(pattern, "/"), "/")
+	segments := strings.SplitAfter(reqPath, "/")
+	if len(segments) <= n {
+		return false
+	}
+	prefix := strings.TrimSuffix(strings.Join(segments[:n+1], ""), "/")
+	ok, _ := path.Match(strings.TrimSuffix(pattern, "/"), prefix)
+	return ok
+}
+
+// RuleFor returns the rule that applies to an outbound request to t, or nil when no
+// rule matches and the default mode applies. When several rules match, the most
+// specific wins: an exact host over a wildcard, a longer wildcard over a shorter one,
+// then a rule with ports over one without, then the longest matching path prefix.
+// Remaining ties go to the rule listed first.
+func (c *Config) RuleFor(t Target) *ExternalRule {
+	var best *ExternalRule
+	var bestScore [3]int
+	for i := range c.External.Rules {
+		rule := &c.External.Rules[i]
+		score, ok := rule.specificity(t)
+		if !ok {
+			continue
+		}
+		if best == nil || slices.Compare(score[:], bestScore[:]) > 0 {
+			best, bestScore = rule, score
+		}
+	}
+	return best
+}
+
+// Intercepts reports whether a proxy should terminate a CONNECT tunnel to t rather than
+// pass it through. That is the case when TLS interception is enabled and some rule for
+// the host and port needs to see the requests inside: it injects a credential,
+// originates mTLS, restricts methods or paths, or denies some paths. Hosts whose rules
+// are all passthrough stay tunnelled.
+func (c *Config) Intercepts(t Target) bool {
+	if c.TLSInterception == nil || c.IsGateway(t.Host) {
+		return false
+	}
+	for i := range c.External.Rules {
+		rule := &c.External.Rules[i]
+		if !MatchHost(rule.Host, t.Host) || (len(rule.Ports) > 0 && !slices.Contains(rule.Ports, t.Port)) {
+			continue
+		}
+		switch {
+		case rule.Mode == ModeVault, rule.Mode == ModeExchange, rule.Mode == ModeSecret, rule.Mode == ModeMTLS:
+			return true
+		case len(rule.AllowedMethods) > 0, len(rule.AllowedPaths) > 0:
+			return true
+		case rule.Mode == ModeDeny && len(rule.Paths) > 0:
+			return true
+		}
+	}
+	return false
+}
+
+// IsGateway reports whether host is the in-cluster agent gateway.
+func (c *Config) IsGateway(host string) bool {
+	return MatchHost(c.Gateway.Host, host)
+}
+
+// NormalizeHost lower-cases a host and strips any port, IPv6 brackets and trailing dot.
+func NormalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}